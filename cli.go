@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gosched/client"
+	"github.com/gosched/scheduler/pb"
+	"github.com/robfig/cron/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "serve", usage: "start the scheduler", run: serve},
+	{name: "config", usage: "validate or print the configuration, or print its JSON Schema", run: config},
+	{name: "submit", usage: "register a task", run: submit},
+	{name: "list", usage: "list tasks", run: list},
+	{name: "get", usage: "show a task", run: get},
+	{name: "cancel", usage: "cancel a pending task", run: cancel},
+	{name: "retry", usage: "make a failed or cancelled task pending again", run: retry},
+	{name: "dlq", usage: "list tasks that failed permanently", run: dlq},
+	{name: "stats", usage: "show worker counters and the number of tasks by status", run: stats},
+	{name: "reload", usage: "reload grouping strategies, the sink and limits of the scheduler", run: reload},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gosched <command> [flags]\n\ncommands:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.usage)
+	}
+	w.Flush()
+	fmt.Fprintln(os.Stderr, "\nrun gosched <command> -h for the flags of the command")
+}
+
+func runCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		// gosched -config_file conf.yaml starts the server like it used to
+		args = append([]string{"serve"}, args...)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// clientFlags are flags of commands talking to a running scheduler.
+type clientFlags struct {
+	addr    string
+	output  string
+	timeout time.Duration
+}
+
+func newClientFlags(name string) (*flag.FlagSet, *clientFlags) {
+	var (
+		fs = flag.NewFlagSet(name, flag.ExitOnError)
+		cf = &clientFlags{}
+	)
+	fs.StringVar(&cf.addr, "addr", "localhost:8080", "address of the scheduler")
+	fs.StringVar(&cf.output, "output", "table", "output format, table or json")
+	fs.DurationVar(&cf.timeout, "timeout", 10*time.Second, "timeout of the command")
+	return fs, cf
+}
+
+func (cf *clientFlags) dial() (context.Context, *client.Client, func(), error) {
+	if cf.output != "table" && cf.output != "json" {
+		return nil, nil, nil, fmt.Errorf("unsupported output %q", cf.output)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cf.timeout)
+	c, err := client.Dial(ctx, cf.addr)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	return ctx, c, func() {
+		c.Close()
+		cancel()
+	}, nil
+}
+
+func (cf *clientFlags) print(msg proto.Message, table func(io.Writer)) error {
+	if cf.output == "json" {
+		data, err := protojson.MarshalOptions{Multiline: true, EmitUnpopulated: true}.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(os.Stdout, string(data))
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// keyValues collects repeated key=value flags.
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	pairs := make([]string, 0, len(kv))
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValues) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return errors.New("expected key=value")
+	}
+	kv[k] = v
+	return nil
+}
+
+func submit(args []string) error {
+	var (
+		fs, cf   = newClientFlags("submit")
+		params   = keyValues{}
+		labels   = keyValues{}
+		method   = fs.String("method", "", "method of the sink the task is delivered to")
+		at       = fs.String("at", "", "delivery time in RFC3339, now by default")
+		in       = fs.Duration("in", 0, "delivery time relative to now")
+		spec     = fs.String("cron", "", "cron expression, tasks are registered for its next occurrences")
+		count    = fs.Int("count", 1, "number of cron occurrences to register")
+		callback = fs.String("callback", "", "url notified about the result of the delivery")
+	)
+	fs.Var(params, "param", "parameter sent to the sink as key=value, can be repeated")
+	fs.Var(labels, "label", "label as key=value, can be repeated")
+	fs.Parse(args)
+
+	if *method == "" {
+		return errors.New("empty method")
+	}
+	times, err := submitTimes(*at, *in, *spec, *count)
+	if err != nil {
+		return err
+	}
+
+	ctx, c, done, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	tasks := make([]*client.Task, 0, len(times))
+	for _, t := range times {
+		tasks = append(tasks, &client.Task{
+			Method:      *method,
+			Params:      params,
+			At:          t,
+			CallbackUrl: *callback,
+			Labels:      labels,
+		})
+	}
+	ids, err := c.ScheduleBatch(ctx, tasks)
+	if err != nil {
+		return err
+	}
+
+	return cf.print(&pb.RegisterBatchResponse{Ids: ids}, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tAT")
+		for i, id := range ids {
+			fmt.Fprintf(w, "%d\t%s\n", id, times[i].Format(time.RFC3339))
+		}
+	})
+}
+
+// submitTimes returns delivery times of the submitted tasks, only one of at,
+// in and spec may be set.
+func submitTimes(at string, in time.Duration, spec string, count int) ([]time.Time, error) {
+	set := 0
+	for _, ok := range []bool{at != "", in != 0, spec != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, errors.New("only one of -at, -in and -cron can be used")
+	}
+
+	now := time.Now()
+	switch {
+	case at != "":
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return nil, err
+		}
+		return []time.Time{t}, nil
+	case in != 0:
+		return []time.Time{now.Add(in)}, nil
+	case spec != "":
+		if count <= 0 {
+			return nil, errors.New("count must be positive")
+		}
+		sched, err := cron.ParseStandard(spec)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			times = make([]time.Time, 0, count)
+			t     = now
+		)
+		for len(times) < count {
+			t = sched.Next(t)
+			if t.IsZero() {
+				break
+			}
+			times = append(times, t)
+		}
+		return times, nil
+	}
+	return []time.Time{now}, nil
+}
+
+func list(args []string) error {
+	var (
+		fs, cf = newClientFlags("list")
+		f      = client.ListFilter{}
+	)
+	fs.StringVar(&f.Method, "method", "", "list only tasks of the method")
+	fs.StringVar(&f.Status, "status", "", "list only tasks in status pending, completed, failed or cancelled")
+	fs.IntVar(&f.Limit, "limit", 100, "maximum number of tasks")
+	fs.IntVar(&f.Offset, "offset", 0, "number of tasks skipped")
+	fs.Parse(args)
+
+	return listTasks(cf, f)
+}
+
+func dlq(args []string) error {
+	var (
+		fs, cf = newClientFlags("dlq")
+		f      = client.ListFilter{Status: "failed"}
+	)
+	fs.StringVar(&f.Method, "method", "", "list only tasks of the method")
+	fs.IntVar(&f.Limit, "limit", 100, "maximum number of tasks")
+	fs.IntVar(&f.Offset, "offset", 0, "number of tasks skipped")
+	fs.Parse(args)
+
+	return listTasks(cf, f)
+}
+
+func listTasks(cf *clientFlags, f client.ListFilter) error {
+	ctx, c, done, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	tasks, err := c.ListTasks(ctx, f)
+	if err != nil {
+		return err
+	}
+	return cf.print(&pb.TaskList{Tasks: tasks}, func(w io.Writer) {
+		printTaskHeader(w)
+		for _, t := range tasks {
+			printTask(w, t)
+		}
+	})
+}
+
+func get(args []string) error {
+	return taskCommand("get", args, (*client.Client).GetTask)
+}
+
+func cancel(args []string) error {
+	return taskCommand("cancel", args, (*client.Client).CancelTask)
+}
+
+func retry(args []string) error {
+	return taskCommand("retry", args, (*client.Client).RetryTask)
+}
+
+// taskCommand runs commands taking a task id as the only argument.
+func taskCommand(name string, args []string, call func(*client.Client, context.Context, int64) (*pb.TaskInfo, error)) error {
+	fs, cf := newClientFlags(name)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: gosched %s [flags] <id>\n", name)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a task id")
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid task id %q", fs.Arg(0))
+	}
+
+	ctx, c, done, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	t, err := call(c, ctx, id)
+	if err != nil {
+		return err
+	}
+	return cf.print(t, func(w io.Writer) {
+		printTaskHeader(w)
+		printTask(w, t)
+	})
+}
+
+func printTaskHeader(w io.Writer) {
+	fmt.Fprintln(w, "ID\tMETHOD\tAT\tSTATUS\tRETRIES\tPARAMS\tLABELS")
+}
+
+func printTask(w io.Writer, t *pb.TaskInfo) {
+	fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
+		t.Id, t.Method, t.At, t.Status, t.Retries, keyValues(t.Params), keyValues(t.Labels))
+}
+
+func stats(args []string) error {
+	fs, cf := newClientFlags("stats")
+	fs.Parse(args)
+
+	ctx, c, done, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	res, err := c.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	return cf.print(res, func(w io.Writer) {
+		fmt.Fprintln(w, "WORKER\tTOTAL\tSUCCEED\tFAILED\tGROUPED\tDEFERRED")
+		for _, s := range res.Workers {
+			fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\n",
+				s.Worker, s.Total, s.Succeed, s.Failed, s.Grouped, s.Deferred)
+		}
+		fmt.Fprintln(w, "\nSTATUS\tTASKS")
+		statuses := make([]string, 0, len(res.Tasks))
+		for status := range res.Tasks {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(w, "%s\t%d\n", status, res.Tasks[status])
+		}
+	})
+}
+
+func reload(args []string) error {
+	fs, cf := newClientFlags("reload")
+	fs.Parse(args)
+
+	ctx, c, done, err := cf.dial()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if err := c.ReloadConfig(ctx); err != nil {
+		return err
+	}
+	return cf.print(&pb.ReloadConfigResponse{}, func(w io.Writer) {
+		fmt.Fprintln(w, "configuration reloaded")
+	})
+}
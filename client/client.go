@@ -0,0 +1,373 @@
+// Package client registers tasks in a running gosched instance.
+//
+// It talks drpc to the scheduler port and falls back to the http bridge
+// served on the same port when configured with WithHTTP. Every task gets an
+// idempotency key which is kept between retries, so a retried registration
+// doesn't create the task twice.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gosched/scheduler/pb"
+	"storj.io/drpc"
+	"storj.io/drpc/drpcconn"
+	"storj.io/drpc/drpcmigrate"
+)
+
+const (
+	defaultRetries   = 3
+	defaultBackoff   = 100 * time.Millisecond
+	maxBackoff       = 5 * time.Second
+	defaultBatchSize = 100
+)
+
+// Task is a task registered in the scheduler.
+type Task struct {
+	Method string
+	Params map[string]string
+	At     time.Time
+	// CallbackUrl is notified about the result of the delivery.
+	CallbackUrl string
+	// Labels are not sent to the sink, they let producers find their tasks.
+	Labels map[string]string
+	// IdempotencyKey is generated for every call when empty, so scheduling
+	// the same Task twice registers it twice. Retries of a call reuse the key.
+	IdempotencyKey string
+}
+
+// toPb returns the request registering the task, the task itself isn't
+// modified.
+func (t *Task) toPb() (*pb.Task, error) {
+	if t.Method == "" {
+		return nil, errors.New("empty method")
+	}
+	key := t.IdempotencyKey
+	if key == "" {
+		var err error
+		if key, err = newIdempotencyKey(); err != nil {
+			return nil, err
+		}
+	}
+	return &pb.Task{
+		Method:         t.Method,
+		Params:         t.Params,
+		At:             t.At.Format(time.RFC3339),
+		CallbackUrl:    t.CallbackUrl,
+		Labels:         t.Labels,
+		IdempotencyKey: key,
+	}, nil
+}
+
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type options struct {
+	retries   int
+	backoff   time.Duration
+	batchSize int
+	httpURL   string
+}
+
+type Option func(*options)
+
+// WithRetries sets how many times a failed registration is retried.
+func WithRetries(retries int) Option {
+	return func(o *options) {
+		o.retries = retries
+	}
+}
+
+// WithBackoff sets the delay before the first retry, it doubles with every
+// following one.
+func WithBackoff(backoff time.Duration) Option {
+	return func(o *options) {
+		o.backoff = backoff
+	}
+}
+
+// WithBatchSize sets how many tasks ScheduleBatch sends in one call.
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+// WithHTTP makes the client use the http bridge at baseURL, e.g.
+// http://localhost:8080, when it can't connect over drpc.
+func WithHTTP(baseURL string) Option {
+	return func(o *options) {
+		o.httpURL = baseURL
+	}
+}
+
+// transport sends requests to the scheduler.
+type transport interface {
+	Register(context.Context, *pb.Task) (*pb.RegisterResponse, error)
+	RegisterBatch(context.Context, *pb.TaskBatch) (*pb.RegisterBatchResponse, error)
+	GetTask(context.Context, *pb.TaskRequest) (*pb.TaskInfo, error)
+	ListTasks(context.Context, *pb.ListTasksRequest) (*pb.TaskList, error)
+	CancelTask(context.Context, *pb.TaskRequest) (*pb.TaskInfo, error)
+	RetryTask(context.Context, *pb.TaskRequest) (*pb.TaskInfo, error)
+	Stats(context.Context, *pb.StatsRequest) (*pb.StatsResponse, error)
+	ReloadConfig(context.Context, *pb.ReloadConfigRequest) (*pb.ReloadConfigResponse, error)
+}
+
+// Client is safe for concurrent use.
+type Client struct {
+	addr string
+	opts options
+
+	mu   sync.Mutex
+	conn *drpcconn.Conn
+	http *httpTransport
+}
+
+// Dial connects to the scheduler listening on addr. With WithHTTP the client
+// is returned even when the drpc connection fails, requests then go over http.
+func Dial(ctx context.Context, addr string, opts ...Option) (*Client, error) {
+	c := &Client{
+		addr: addr,
+		opts: options{
+			retries:   defaultRetries,
+			backoff:   defaultBackoff,
+			batchSize: defaultBatchSize,
+		},
+	}
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+	if c.opts.batchSize <= 0 {
+		return nil, errors.New("batch size must be positive")
+	}
+	if c.opts.httpURL != "" {
+		c.http = newHTTPTransport(c.opts.httpURL)
+	}
+
+	if _, err := c.transport(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// transport returns the drpc connection, dialing again when the previous one
+// was closed, or the http transport when dialing fails.
+func (c *Client) transport(ctx context.Context) (transport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		select {
+		case <-c.conn.Closed():
+			c.conn = nil
+		default:
+			return pb.NewDRPCSchedulerServerClient(c.conn), nil
+		}
+	}
+
+	raw, err := drpcmigrate.DialWithHeader(ctx, "tcp", c.addr, drpcmigrate.DRPCHeader)
+	if err != nil {
+		if c.http != nil {
+			return c.http, nil
+		}
+		return nil, err
+	}
+	c.conn = drpcconn.New(raw)
+	return pb.NewDRPCSchedulerServerClient(c.conn), nil
+}
+
+// Schedule registers a task delivered to method at the given time and
+// returns its id.
+func (c *Client) Schedule(ctx context.Context, method string, params map[string]string, at time.Time) (int64, error) {
+	return c.ScheduleTask(ctx, &Task{
+		Method: method,
+		Params: params,
+		At:     at,
+	})
+}
+
+// ScheduleTask registers the task and returns its id.
+func (c *Client) ScheduleTask(ctx context.Context, t *Task) (int64, error) {
+	pbt, err := t.toPb()
+	if err != nil {
+		return 0, err
+	}
+
+	var res *pb.RegisterResponse
+	err = c.retry(ctx, func(tr transport) (err error) {
+		res, err = tr.Register(ctx, pbt)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.Id, nil
+}
+
+// ScheduleBatch registers tasks in batches of the configured size and returns
+// their ids in order. On error the ids of already registered tasks are
+// returned with it.
+func (c *Client) ScheduleBatch(ctx context.Context, tasks []*Task) ([]int64, error) {
+	batch := make([]*pb.Task, 0, len(tasks))
+	for i, t := range tasks {
+		pbt, err := t.toPb()
+		if err != nil {
+			return nil, fmt.Errorf("task %d: %w", i, err)
+		}
+		batch = append(batch, pbt)
+	}
+
+	ids := make([]int64, 0, len(tasks))
+	for len(batch) > 0 {
+		n := min(len(batch), c.opts.batchSize)
+		var res *pb.RegisterBatchResponse
+		err := c.retry(ctx, func(tr transport) (err error) {
+			res, err = tr.RegisterBatch(ctx, &pb.TaskBatch{Tasks: batch[:n]})
+			return err
+		})
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, res.Ids...)
+		batch = batch[n:]
+	}
+	return ids, nil
+}
+
+// ListFilter selects tasks returned by ListTasks, empty fields match every task.
+type ListFilter struct {
+	Method string
+	// Status is one of pending, completed, failed, cancelled.
+	Status string
+	Limit  int
+	Offset int
+}
+
+// GetTask returns the task with the given id.
+func (c *Client) GetTask(ctx context.Context, id int64) (*pb.TaskInfo, error) {
+	var res *pb.TaskInfo
+	err := c.retry(ctx, func(tr transport) (err error) {
+		res, err = tr.GetTask(ctx, &pb.TaskRequest{Id: id})
+		return err
+	})
+	return res, err
+}
+
+// ListTasks returns tasks ordered by id.
+func (c *Client) ListTasks(ctx context.Context, f ListFilter) ([]*pb.TaskInfo, error) {
+	var res *pb.TaskList
+	err := c.retry(ctx, func(tr transport) (err error) {
+		res, err = tr.ListTasks(ctx, &pb.ListTasksRequest{
+			Method: f.Method,
+			Status: f.Status,
+			Limit:  int32(f.Limit),
+			Offset: int32(f.Offset),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Tasks, nil
+}
+
+// CancelTask cancels a pending task and returns it.
+func (c *Client) CancelTask(ctx context.Context, id int64) (*pb.TaskInfo, error) {
+	var res *pb.TaskInfo
+	err := c.retry(ctx, func(tr transport) (err error) {
+		res, err = tr.CancelTask(ctx, &pb.TaskRequest{Id: id})
+		return err
+	})
+	return res, err
+}
+
+// RetryTask makes a failed or cancelled task pending again and returns it.
+func (c *Client) RetryTask(ctx context.Context, id int64) (*pb.TaskInfo, error) {
+	var res *pb.TaskInfo
+	err := c.retry(ctx, func(tr transport) (err error) {
+		res, err = tr.RetryTask(ctx, &pb.TaskRequest{Id: id})
+		return err
+	})
+	return res, err
+}
+
+// Stats returns delivery counters of workers and the number of tasks by status.
+func (c *Client) Stats(ctx context.Context) (*pb.StatsResponse, error) {
+	var res *pb.StatsResponse
+	err := c.retry(ctx, func(tr transport) (err error) {
+		res, err = tr.Stats(ctx, &pb.StatsRequest{})
+		return err
+	})
+	return res, err
+}
+
+// ReloadConfig makes the scheduler reload its configuration.
+func (c *Client) ReloadConfig(ctx context.Context) error {
+	return c.retry(ctx, func(tr transport) error {
+		_, err := tr.ReloadConfig(ctx, &pb.ReloadConfigRequest{})
+		return err
+	})
+}
+
+// retry calls f until it succeeds, the retries run out or ctx is done.
+// Only connection failures are retried, errors returned by the scheduler are
+// not. Registrations are safe to repeat because of idempotency keys.
+func (c *Client) retry(ctx context.Context, f func(transport) error) error {
+	backoff := c.opts.backoff
+	for attempt := 0; ; attempt++ {
+		tr, err := c.transport(ctx)
+		if err == nil {
+			err = f(tr)
+			if err == nil {
+				return nil
+			}
+		}
+		if attempt >= c.opts.retries || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// retryable reports whether err is a connection failure. Drpc cancels calls
+// of a broken connection, retry checks that the caller's context isn't done.
+func retryable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		drpc.ClosedError.Has(err)
+}
+
+// Close closes the drpc connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
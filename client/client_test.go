@@ -0,0 +1,305 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gosched/client"
+	"github.com/gosched/scheduler"
+	sqlitedb "github.com/gosched/sqliteDb"
+)
+
+// discard accepts every task, tests schedule them an hour ahead so none is
+// delivered.
+type discard struct{}
+
+func (discard) Handle(*scheduler.Task) error { return nil }
+
+// startScheduler runs a scheduler with a fresh database on a loopback port
+// and returns its address.
+func startScheduler(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := sqlitedb.NewSqliteHandler(filepath.Join(dir, "scheduler.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := freeAddr(t)
+	ticker := time.Hour
+	s, err := scheduler.NewScheduler(filepath.Join(dir, "scheduler.log"),
+		scheduler.WithDatabase(db),
+		scheduler.WithHandler(discard{}),
+		scheduler.WithPort(addr),
+		scheduler.WithTicker(&ticker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the scheduler can't be stopped, it lives until the tests end
+	go s.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scheduler isn't listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func dial(t *testing.T, addr string, opts ...client.Option) *client.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, addr, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func countTasks(t *testing.T, c *client.Client, method string) int {
+	t.Helper()
+	tasks, err := c.ListTasks(context.Background(), client.ListFilter{Method: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(tasks)
+}
+
+var at = time.Now().Add(time.Hour)
+
+func TestSchedule(t *testing.T) {
+	c := dial(t, startScheduler(t))
+	ctx := context.Background()
+
+	id, err := c.Schedule(ctx, "schedule", map[string]string{"user": "zuzia"}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= 0 {
+		t.Fatalf("got id %d", id)
+	}
+	task, err := c.GetTask(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Method != "schedule" || task.Params["user"] != "zuzia" || task.Status != "pending" {
+		t.Fatalf("got task %v", task)
+	}
+
+	// a task scheduled twice without a key is registered twice
+	tt := &client.Task{Method: "schedule", At: at}
+	first, err := c.ScheduleTask(ctx, tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.ScheduleTask(ctx, tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("task scheduled twice got the same id %d", first)
+	}
+	if tt.IdempotencyKey != "" {
+		t.Fatalf("task was modified, got key %q", tt.IdempotencyKey)
+	}
+}
+
+func TestScheduleBatch(t *testing.T) {
+	c := dial(t, startScheduler(t), client.WithBatchSize(2))
+	ctx := context.Background()
+
+	tasks := make([]*client.Task, 5)
+	for i := range tasks {
+		tasks[i] = &client.Task{Method: "batch", At: at}
+	}
+	ids, err := c.ScheduleBatch(ctx, tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(tasks) {
+		t.Fatalf("got %d ids, want %d", len(ids), len(tasks))
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("ids aren't in order: %v", ids)
+		}
+	}
+	if n := countTasks(t, c, "batch"); n != len(tasks) {
+		t.Fatalf("got %d tasks, want %d", n, len(tasks))
+	}
+
+	// an invalid task is rejected before anything is sent
+	tasks = append(tasks, &client.Task{At: at})
+	if _, err := c.ScheduleBatch(ctx, tasks); err == nil {
+		t.Fatal("expected error for a task without method")
+	}
+	if n := countTasks(t, c, "batch"); n != 5 {
+		t.Fatalf("got %d tasks after rejected batch, want 5", n)
+	}
+}
+
+// flakyProxy forwards connections to addr and drops the first response, as
+// if the connection broke after the scheduler handled the request.
+type flakyProxy struct {
+	addr string
+	lis  net.Listener
+
+	mu      sync.Mutex
+	dropped bool
+}
+
+func newFlakyProxy(t *testing.T, addr string) *flakyProxy {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &flakyProxy{addr: addr, lis: lis}
+	t.Cleanup(func() { lis.Close() })
+	go p.serve()
+	return p
+}
+
+func (p *flakyProxy) hasDropped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+func (p *flakyProxy) serve() {
+	for {
+		conn, err := p.lis.Accept()
+		if err != nil {
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+func (p *flakyProxy) forward(conn net.Conn) {
+	defer conn.Close()
+	upstream, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	p.mu.Lock()
+	drop := !p.dropped
+	p.dropped = true
+	p.mu.Unlock()
+
+	go io.Copy(upstream, conn)
+	if drop {
+		// wait for the response and close both sides without sending it
+		buf := make([]byte, 1)
+		_, _ = upstream.Read(buf)
+		return
+	}
+	_, _ = io.Copy(conn, upstream)
+}
+
+func TestScheduleRetryReusesIdempotencyKey(t *testing.T) {
+	addr := startScheduler(t)
+	proxy := newFlakyProxy(t, addr)
+	c := dial(t, proxy.lis.Addr().String(), client.WithBackoff(10*time.Millisecond))
+	ctx := context.Background()
+
+	id, err := c.Schedule(ctx, "retry", nil, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proxy.hasDropped() {
+		t.Fatal("response wasn't dropped")
+	}
+
+	// the first attempt was registered, the retry returned its id
+	direct := dial(t, addr)
+	if n := countTasks(t, direct, "retry"); n != 1 {
+		t.Fatalf("got %d tasks, want 1", n)
+	}
+	task, err := direct.GetTask(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Method != "retry" {
+		t.Fatalf("got task %v", task)
+	}
+}
+
+func TestScheduleWithExplicitKey(t *testing.T) {
+	c := dial(t, startScheduler(t))
+	ctx := context.Background()
+
+	tt := &client.Task{Method: "explicit", At: at, IdempotencyKey: "order-42"}
+	first, err := c.ScheduleTask(ctx, tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.ScheduleTask(ctx, tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("got ids %d and %d for the same key", first, second)
+	}
+	if n := countTasks(t, c, "explicit"); n != 1 {
+		t.Fatalf("got %d tasks, want 1", n)
+	}
+}
+
+func TestHTTPFallback(t *testing.T) {
+	addr := startScheduler(t)
+	// nothing listens there, so dialing drpc fails
+	down := freeAddr(t)
+	if _, err := client.Dial(context.Background(), down, client.WithRetries(0)); err == nil {
+		t.Fatal("expected dial error without http fallback")
+	}
+
+	c := dial(t, down, client.WithHTTP("http://"+addr))
+	ctx := context.Background()
+
+	id, err := c.Schedule(ctx, "fallback", map[string]string{"user": "zuzia"}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := c.ScheduleBatch(ctx, []*client.Task{
+		{Method: "fallback", At: at},
+		{Method: "fallback", At: at},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] <= id || ids[1] <= ids[0] {
+		t.Fatalf("got ids %d, %v", id, ids)
+	}
+	task, err := c.GetTask(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Params["user"] != "zuzia" {
+		t.Fatalf("got task %v", task)
+	}
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gosched/scheduler/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// httpTransport calls the drpc http bridge, it accepts and returns protojson.
+type httpTransport struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newHTTPTransport(baseURL string) *httpTransport {
+	return &httpTransport{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  http.DefaultClient,
+	}
+}
+
+func (t *httpTransport) Register(ctx context.Context, in *pb.Task) (*pb.RegisterResponse, error) {
+	out := &pb.RegisterResponse{}
+	if err := t.invoke(ctx, "Register", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *httpTransport) RegisterBatch(ctx context.Context, in *pb.TaskBatch) (*pb.RegisterBatchResponse, error) {
+	out := &pb.RegisterBatchResponse{}
+	if err := t.invoke(ctx, "RegisterBatch", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *httpTransport) GetTask(ctx context.Context, in *pb.TaskRequest) (*pb.TaskInfo, error) {
+	out := &pb.TaskInfo{}
+	if err := t.invoke(ctx, "GetTask", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *httpTransport) ListTasks(ctx context.Context, in *pb.ListTasksRequest) (*pb.TaskList, error) {
+	out := &pb.TaskList{}
+	if err := t.invoke(ctx, "ListTasks", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *httpTransport) CancelTask(ctx context.Context, in *pb.TaskRequest) (*pb.TaskInfo, error) {
+	out := &pb.TaskInfo{}
+	if err := t.invoke(ctx, "CancelTask", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *httpTransport) RetryTask(ctx context.Context, in *pb.TaskRequest) (*pb.TaskInfo, error) {
+	out := &pb.TaskInfo{}
+	if err := t.invoke(ctx, "RetryTask", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *httpTransport) Stats(ctx context.Context, in *pb.StatsRequest) (*pb.StatsResponse, error) {
+	out := &pb.StatsResponse{}
+	if err := t.invoke(ctx, "Stats", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *httpTransport) ReloadConfig(ctx context.Context, in *pb.ReloadConfigRequest) (*pb.ReloadConfigResponse, error) {
+	out := &pb.ReloadConfigResponse{}
+	if err := t.invoke(ctx, "ReloadConfig", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *httpTransport) invoke(ctx context.Context, rpc string, in, out proto.Message) error {
+	body, err := protojson.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	url := t.baseURL + "/scheduler.SchedulerServer/" + rpc
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		// the bridge answers with {"code": ..., "msg": ...}
+		var e struct {
+			Msg string `json:"msg"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Msg != "" {
+			return fmt.Errorf("%s: %s", rpc, e.Msg)
+		}
+		return fmt.Errorf("%s: unexpected status %d", rpc, res.StatusCode)
+	}
+	return protojson.Unmarshal(data, out)
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gosched/jsonschema"
+	"github.com/gosched/scheduler"
+	sqlitedb "github.com/gosched/sqliteDb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gopkg.in/yaml.v3"
+)
+
+type GroupingStrategy struct {
+	// Name only identifies the strategy in the file.
+	Name              string        `yaml:"name,omitempty" description:"Name of the strategy, not used by the scheduler."`
+	Method            string        `yaml:"method" description:"Method whose tasks are grouped."`
+	Kind              string        `yaml:"kind,omitempty" description:"Either fixed, windows of time_format, or rolling, windows of window starting with a delivery."`
+	TimeFormat        string        `yaml:"time_format,omitempty" description:"Go time layout applied to the delivery time, tasks in the same formatted time are grouped."`
+	Window            time.Duration `yaml:"window,omitempty" description:"Duration of rolling windows, of throttle windows and the minimum time between debounced deliveries."`
+	Quiet             time.Duration `yaml:"quiet,omitempty" description:"Time the debounce mode waits for a newer task."`
+	Mode              string        `yaml:"mode,omitempty" description:"Either first, delivering the first max tasks of a window, merge, delivering one digest of the window when it closes, replace, replacing a pending task with the last one registered, debounce, delivering the last one quiet after its time, or throttle, delivering the first one and grouping others within window."`
+	Max               int           `yaml:"max,omitempty" description:"Number of tasks delivered per window by the first mode, 1 by default."`
+	KeepEarliest      bool          `yaml:"keep_earliest,omitempty" description:"Keep the earlier time of a task replaced by the replace mode."`
+	GroupingParameter []string      `yaml:"param,omitempty" description:"Parameters whose values are part of the grouping key."`
+}
+
+func (g GroupingStrategy) toStrategy() scheduler.GroupingStrategy {
+	return scheduler.GroupingStrategy{
+		Method:       g.Method,
+		Kind:         scheduler.GroupingKind(g.Kind),
+		Mode:         scheduler.GroupingMode(g.Mode),
+		TimeFormat:   g.TimeFormat,
+		Window:       g.Window,
+		Quiet:        g.Quiet,
+		Max:          g.Max,
+		KeepEarliest: g.KeepEarliest,
+		Param:        g.GroupingParameter,
+	}
+}
+
+type Limit struct {
+	Method      string  `yaml:"method,omitempty" description:"Method the limit applies to, not used by sink_limit."`
+	MaxInFlight int     `yaml:"max_in_flight,omitempty" description:"Maximum number of concurrent deliveries, 0 is unlimited."`
+	Rate        float64 `yaml:"rate,omitempty" description:"Deliveries per second, 0 is unlimited."`
+	Burst       int     `yaml:"burst,omitempty" description:"Deliveries allowed at once above the rate."`
+}
+
+func (l Limit) toLimit() scheduler.Limit {
+	return scheduler.Limit{
+		MaxInFlight: l.MaxInFlight,
+		Rate:        l.Rate,
+		Burst:       l.Burst,
+	}
+}
+
+type Breaker struct {
+	FailureThreshold int           `yaml:"failure_threshold,omitempty" description:"Consecutive failures that open the circuit, 5 by default."`
+	CoolDown         time.Duration `yaml:"cool_down,omitempty" description:"Time the circuit stays open before probing the sink, 30s by default."`
+	HalfOpenRequests int           `yaml:"half_open_requests,omitempty" description:"Probes allowed while half-open, 1 by default."`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" description:"Either stdout or file."`
+	Path     string `yaml:"path,omitempty" description:"File spans are appended to, required by the file exporter."`
+}
+
+func (t *Tracing) provider() (*sdktrace.TracerProvider, error) {
+	var out io.Writer
+	switch t.Exporter {
+	case "stdout":
+		out = os.Stdout
+	case "file":
+		f, err := os.OpenFile(t.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(out))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "gosched"))),
+	), nil
+}
+
+type Config struct {
+	DatabaseType string `yaml:"database_type,omitempty" description:"Only sqlite is supported."`
+	DatabasePath string `yaml:"database_path,omitempty" description:"Path of the database file."`
+
+	Port string `yaml:"port,omitempty" description:"Port of the drpc and http apis."`
+
+	SinkType    string `yaml:"sink_type,omitempty" description:"Only http is supported."`
+	SinkAddress string `yaml:"sink_address" description:"Url tasks are delivered to, the method is appended to its path."`
+
+	SinkLog      string `yaml:"sink_log,omitempty" description:"Log file of the sink handler."`
+	SchedulerLog string `yaml:"scheduler_log,omitempty" description:"Log file of the scheduler, stdout when empty."`
+	LogLevel     string `yaml:"log_level,omitempty" description:"One of debug, info, warn, error."`
+
+	BatchSize int           `yaml:"batch_size,omitempty" description:"Maximum number of tasks a worker delivers in one transaction."`
+	Ticker    time.Duration `yaml:"ticker,omitempty" description:"Interval between scans of the database."`
+
+	Workers   int    `yaml:"workers,omitempty" description:"Number of workers delivering tasks concurrently."`
+	Partition string `yaml:"partition,omitempty" description:"How tasks are assigned to workers, by method or by id."`
+
+	GroupingStrategy []GroupingStrategy `yaml:"grouping,omitempty" description:"Grouping strategies, at most one per method."`
+	DedupStore       string             `yaml:"dedup_store,omitempty" description:"Where keys of delivered groups are kept, database or memory."`
+
+	Limits    []Limit `yaml:"limits,omitempty" description:"Delivery limits per method."`
+	SinkLimit Limit   `yaml:"sink_limit,omitempty" description:"Delivery limits shared by all methods."`
+
+	SinkBreaker *Breaker `yaml:"sink_breaker,omitempty" description:"Circuit breaker around the sink, disabled when missing."`
+
+	Tracing *Tracing `yaml:"tracing,omitempty" description:"Span exporter, tracing is disabled when missing."`
+}
+
+// defaultConfig returns the values of fields missing in the configuration file.
+func defaultConfig() Config {
+	return Config{
+		DatabaseType: "sqlite",
+		DatabasePath: "./scheduler/scheduler.db",
+		Port:         ":8080",
+		SinkType:     "http",
+		SinkLog:      "./sink.log",
+		LogLevel:     "debug",
+		BatchSize:    1000,
+		Ticker:       10 * time.Second,
+		Workers:      1,
+		Partition:    "method",
+		DedupStore:   "database",
+	}
+}
+
+// readConfig reads the configuration file over the defaults. Without a file
+// the configuration has only defaults.
+func readConfig(path string) (Config, error) {
+	conf := defaultConfig()
+	if path == "" {
+		return conf, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(&conf); err != nil && !errors.Is(err, io.EOF) {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// fieldError is a problem with the value at path, e.g. grouping[1].method.
+type fieldError struct {
+	path string
+	msg  string
+}
+
+func (e *fieldError) Error() string {
+	return e.path + ": " + e.msg
+}
+
+// validate returns all problems of the configuration joined together.
+func (c *Config) validate() error {
+	var errs []error
+	fail := func(path, format string, args ...any) {
+		errs = append(errs, &fieldError{path: path, msg: fmt.Sprintf(format, args...)})
+	}
+
+	if c.DatabaseType != "sqlite" {
+		fail("database_type", "unsupported database type %q", c.DatabaseType)
+	}
+	if c.DatabasePath == "" {
+		fail("database_path", "empty")
+	}
+	if c.Port == "" {
+		fail("port", "empty")
+	}
+	if c.SinkType != "http" {
+		fail("sink_type", "unsupported sink type %q", c.SinkType)
+	}
+	if c.SinkAddress == "" {
+		fail("sink_address", "empty")
+	} else if _, err := url.ParseRequestURI(c.SinkAddress); err != nil {
+		fail("sink_address", "%v", err)
+	}
+	if c.SinkLog == "" {
+		fail("sink_log", "empty")
+	}
+	if _, err := c.logLevel(); err != nil {
+		fail("log_level", "%v", err)
+	}
+	if c.BatchSize <= 0 {
+		fail("batch_size", "must be positive")
+	}
+	if c.Ticker <= 0 {
+		fail("ticker", "must be positive")
+	}
+	if c.Workers <= 0 {
+		fail("workers", "must be positive")
+	}
+	if _, err := c.partition(); err != nil {
+		fail("partition", "%v", err)
+	}
+	switch c.DedupStore {
+	case "database", "memory":
+	default:
+		fail("dedup_store", "unsupported store %q", c.DedupStore)
+	}
+
+	methods := make(map[string]bool)
+	for i, g := range c.GroupingStrategy {
+		path := fmt.Sprintf("grouping[%d]", i)
+		if g.Method == "" {
+			fail(path+".method", "empty")
+		} else if methods[g.Method] {
+			fail(path+".method", "duplicated method %q", g.Method)
+		}
+		methods[g.Method] = true
+		for _, err := range unjoin(g.toStrategy().Validate()) {
+			fail(path, "%v", err)
+		}
+	}
+
+	limited := make(map[string]bool)
+	for i, l := range c.Limits {
+		path := fmt.Sprintf("limits[%d]", i)
+		if l.Method == "" {
+			fail(path+".method", "empty")
+		} else if limited[l.Method] {
+			fail(path+".method", "duplicated method %q", l.Method)
+		}
+		limited[l.Method] = true
+		validateLimit(path, l, fail)
+	}
+	validateLimit("sink_limit", c.SinkLimit, fail)
+
+	if b := c.SinkBreaker; b != nil {
+		if b.FailureThreshold < 0 {
+			fail("sink_breaker.failure_threshold", "negative")
+		}
+		if b.CoolDown < 0 {
+			fail("sink_breaker.cool_down", "negative")
+		}
+		if b.HalfOpenRequests < 0 {
+			fail("sink_breaker.half_open_requests", "negative")
+		}
+	}
+
+	if t := c.Tracing; t != nil {
+		switch t.Exporter {
+		case "stdout":
+		case "file":
+			if t.Path == "" {
+				fail("tracing.path", "empty")
+			}
+		default:
+			fail("tracing.exporter", "unsupported exporter %q", t.Exporter)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// unjoin returns the errors joined in err.
+func unjoin(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	if err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func validateLimit(path string, l Limit, fail func(path, format string, args ...any)) {
+	if l.MaxInFlight < 0 {
+		fail(path+".max_in_flight", "negative")
+	}
+	if l.Rate < 0 {
+		fail(path+".rate", "negative")
+	}
+	if l.Burst < 0 {
+		fail(path+".burst", "negative")
+	}
+}
+
+func (c *Config) logLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+func (c *Config) partition() (scheduler.Partition, error) {
+	switch c.Partition {
+	case "method":
+		return scheduler.PartitionByMethod, nil
+	case "id":
+		return scheduler.PartitionById, nil
+	}
+	return 0, fmt.Errorf("unsupported partition %q", c.Partition)
+}
+
+// sink opens the http handler, it is reused by reloads that don't change the
+// sink so its log isn't opened again.
+type sink struct {
+	mu      sync.Mutex
+	address string
+	log     string
+	h       scheduler.Handler
+}
+
+func (s *sink) handler(address, log string) (scheduler.Handler, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.h != nil && s.address == address && s.log == log {
+		return s.h, nil
+	}
+	h := scheduler.NewHttpHandler(address, log)
+	if h == nil {
+		return nil, fmt.Errorf("sink_log: couldn't open %s", log)
+	}
+	s.address, s.log, s.h = address, log, h
+	return h, nil
+}
+
+// settings returns the part of a validated configuration that can be reloaded.
+func (c *Config) settings(sk *sink) (scheduler.Settings, error) {
+	m := make(map[string]scheduler.GroupingStrategy)
+	for _, groupingStrategy := range c.GroupingStrategy {
+		m[groupingStrategy.Method] = groupingStrategy.toStrategy()
+	}
+
+	limits := make(map[string]scheduler.Limit)
+	for _, limit := range c.Limits {
+		limits[limit.Method] = limit.toLimit()
+	}
+
+	h, err := sk.handler(c.SinkAddress, c.SinkLog)
+	if err != nil {
+		return scheduler.Settings{}, err
+	}
+
+	st := scheduler.Settings{
+		GroupingStrategy: m,
+		Handler:          h,
+		Limits:           limits,
+		SinkLimit:        c.SinkLimit.toLimit(),
+	}
+	if c.SinkBreaker != nil {
+		st.Breaker = &scheduler.BreakerConfig{
+			FailureThreshold: c.SinkBreaker.FailureThreshold,
+			CoolDown:         c.SinkBreaker.CoolDown,
+			HalfOpenRequests: c.SinkBreaker.HalfOpenRequests,
+		}
+	}
+	return st, nil
+}
+
+// toOptions returns options of a validated configuration.
+func (c *Config) toOptions(sk *sink) ([]scheduler.Option, error) {
+	level, _ := c.logLevel()
+	partition, _ := c.partition()
+
+	st, err := c.settings(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sqlitedb.NewSqliteHandler(c.DatabasePath, false)
+	if err != nil {
+		return nil, fmt.Errorf("database_path: %w", err)
+	}
+
+	ticker := c.Ticker
+	opts := []scheduler.Option{
+		scheduler.WithDatabase(db),
+		scheduler.WithHandler(st.Handler),
+		scheduler.WithDebugLevel(level),
+		scheduler.WithPort(c.Port),
+		scheduler.WithBatchSize(c.BatchSize),
+		scheduler.WithTicker(&ticker),
+		scheduler.WithGroupingStrategy(st.GroupingStrategy),
+		scheduler.WithWorkers(c.Workers),
+		scheduler.WithPartition(partition),
+		scheduler.WithLimits(st.Limits),
+		scheduler.WithSinkLimit(st.SinkLimit),
+	}
+
+	if st.Breaker != nil {
+		opts = append(opts, scheduler.WithCircuitBreaker(*st.Breaker))
+	}
+
+	if c.DedupStore == "memory" {
+		opts = append(opts, scheduler.WithDedupStore(scheduler.NewMemoryDedupStore()))
+	}
+
+	if c.Tracing != nil {
+		tp, err := c.Tracing.provider()
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		otel.SetTracerProvider(tp)
+		opts = append(opts, scheduler.WithTracerProvider(tp))
+	}
+
+	return opts, nil
+}
+
+// configSchema returns the JSON Schema of the configuration file with the
+// defaults filled in.
+func configSchema() (jsonschema.Schema, error) {
+	s := jsonschema.For(reflect.TypeOf(Config{}), "yaml")
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "gosched configuration"
+
+	raw, err := yaml.Marshal(defaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	var defaults map[string]any
+	if err := yaml.Unmarshal(raw, &defaults); err != nil {
+		return nil, err
+	}
+	props := s["properties"].(jsonschema.Schema)
+	for name, v := range defaults {
+		if p, ok := props[name].(jsonschema.Schema); ok {
+			p["default"] = v
+		}
+	}
+
+	props["database_type"].(jsonschema.Schema)["enum"] = []string{"sqlite"}
+	props["sink_type"].(jsonschema.Schema)["enum"] = []string{"http"}
+	props["log_level"].(jsonschema.Schema)["enum"] = []string{"debug", "info", "warn", "error"}
+	props["partition"].(jsonschema.Schema)["enum"] = []string{"method", "id"}
+	props["dedup_store"].(jsonschema.Schema)["enum"] = []string{"database", "memory"}
+	grouping := props["grouping"].(jsonschema.Schema)["items"].(jsonschema.Schema)["properties"].(jsonschema.Schema)
+	grouping["kind"].(jsonschema.Schema)["enum"] = []string{"fixed", "rolling"}
+	grouping["mode"].(jsonschema.Schema)["enum"] = []string{"first", "merge", "replace", "debounce", "throttle"}
+	tracing := props["tracing"].(jsonschema.Schema)["properties"].(jsonschema.Schema)
+	tracing["exporter"].(jsonschema.Schema)["enum"] = []string{"stdout", "file"}
+	return s, nil
+}
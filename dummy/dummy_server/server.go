@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"log/slog"
 	"net/http"
 )
@@ -29,5 +30,15 @@ func main() {
 		slog.Info("notifying", slog.String("name", name))
 	})
 
+	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		slog.Info("callback", slog.String("event", string(body)))
+	})
+
 	http.ListenAndServe(addr, nil)
 }
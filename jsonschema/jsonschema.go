@@ -0,0 +1,108 @@
+// Package jsonschema generates JSON Schema documents from Go types.
+//
+// Field names are taken from json or yaml tags, fields with omitempty are
+// optional and a description can be given with the description tag.
+package jsonschema
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema document.
+type Schema map[string]any
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// For returns the schema of values of type t. Field names come from the given
+// struct tag, usually "json" or "yaml".
+func For(t reflect.Type, tag string) Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case durationType:
+		return Schema{"type": "string", "pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": For(t.Elem(), tag)}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": For(t.Elem(), tag)}
+	case reflect.Struct:
+		return forStruct(t, tag)
+	}
+	return Schema{}
+}
+
+func forStruct(t reflect.Type, tag string) Schema {
+	var (
+		props    = Schema{}
+		required []string
+	)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, omitempty, skip := fieldName(f, tag)
+		if skip {
+			continue
+		}
+		s := For(f.Type, tag)
+		if desc := f.Tag.Get("description"); desc != "" {
+			s["description"] = desc
+		}
+		props[name] = s
+		if !omitempty && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	res := Schema{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		res["required"] = required
+	}
+	return res
+}
+
+func fieldName(f reflect.StructField, tag string) (name string, omitempty bool, skip bool) {
+	v, ok := f.Tag.Lookup(tag)
+	if !ok {
+		return f.Name, false, false
+	}
+	parts := strings.Split(v, ",")
+	if parts[0] == "-" {
+		return "", false, true
+	}
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty, false
+}
@@ -1,116 +1,90 @@
 package main
 
 import (
-	"errors"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
 
 	"github.com/gosched/scheduler"
-	sqlitedb "github.com/gosched/sqliteDb"
-	"gopkg.in/yaml.v3"
 )
 
-type GroupingStrategy struct {
-	Method            string   `yaml:"method"`
-	TimeFormat        string   `yaml:"time_format"`
-	GroupingParameter []string `yaml:"param"`
-}
-
-type Config struct {
-	DatabaseType string `yaml:"database_type"`
-	DatabasePath string `yaml:"database_path"`
-
-	Port string `yaml:"port"`
-
-	SinkType    string `yaml:"sink_type"`
-	SinkAddress string `yaml:"sink_address"`
-
-	SinkLog      string `yaml:"sink_log"`
-	SchedulerLog string `yaml:"scheduler_log"`
-
-	GroupingStrategy []GroupingStrategy `yaml:"grouping"`
-}
-
-func (c *Config) toOptions() ([]scheduler.Option, error) {
-	if c.DatabaseType != "sqlite" {
-		return nil, errors.New("unsuported database type")
-	}
-
-	if c.DatabasePath == "" {
-		return nil, errors.New("empty database path")
-	}
-
-	if c.Port == "" {
-		return nil, errors.New("empty port")
-	}
-
-	if c.SinkType != "http" {
-		return nil, errors.New("unsuported sink type")
-	}
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	fs.Parse(args)
 
-	if c.SinkAddress == "" {
-		return nil, errors.New("empty sink address")
+	conf, err := cf.load()
+	if err != nil {
+		return err
 	}
-
-	if c.SinkLog == "" {
-		return nil, errors.New("empty sink log")
+	fmt.Println("effective configuration:")
+	if err := printConfig(conf); err != nil {
+		return err
 	}
 
-	if c.SchedulerLog == "" {
-		return nil, errors.New("empty scheduler log")
+	sk := &sink{}
+	opts, err := conf.toOptions(sk)
+	if err != nil {
+		return err
 	}
-
-	m := make(map[string]scheduler.GroupingStrategy)
-	for _, groupingStrategy := range c.GroupingStrategy {
-		m[groupingStrategy.Method] = scheduler.GroupingStrategy{
-			Method:     groupingStrategy.Method,
-			TimeFormat: groupingStrategy.TimeFormat,
-			Param:      groupingStrategy.GroupingParameter,
+	// grouping, the sink and limits are reloaded on SIGHUP and by the
+	// ReloadConfig rpc, other fields need a restart
+	opts = append(opts, scheduler.WithReloader(func() (scheduler.Settings, error) {
+		conf, err := cf.load()
+		if err != nil {
+			return scheduler.Settings{}, err
 		}
-	}
+		return conf.settings(sk)
+	}))
 
-	db, err := sqlitedb.NewSqliteHandler("./scheduler/scheduler.db", true)
+	s, err := scheduler.NewScheduler(conf.SchedulerLog, opts...)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	return []scheduler.Option{
-		scheduler.WithDatabase(db),
-		scheduler.WithHandler(scheduler.NewHttpHandler(c.SinkAddress, c.SinkLog)),
-		scheduler.WithPort(c.Port),
-		scheduler.WithBatchSize(1000),
-		scheduler.WithGroupingStrategy(m),
-	}, nil
+	s.Start()
+	return nil
 }
 
-func main() {
-	conff := flag.String("config_file", "", "path to a configuration file")
-
-	flag.Parse()
-
-	if conff == nil || *conff == "" {
-		panic(errors.New("empty config file"))
+// config validates configuration files and prints their schema.
+func config(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gosched config validate [flags]\n       gosched config print [flags]\n       gosched config schema")
 	}
+	fs.Parse(args)
 
-	rawConf, err := os.ReadFile(*conff)
-	if err != nil {
-		panic(err)
-	}
-
-	conf := Config{}
-	err = yaml.Unmarshal(rawConf, &conf)
-	if err != nil {
-		panic(err)
-	}
+	switch fs.Arg(0) {
+	case "validate", "print":
+		vfs := flag.NewFlagSet("config "+fs.Arg(0), flag.ExitOnError)
+		cf := newConfigFlags(vfs)
+		vfs.Parse(fs.Args()[1:])
 
-	opts, err := conf.toOptions()
-	if err != nil {
-		panic(err)
+		conf, err := cf.load()
+		if err != nil {
+			return fmt.Errorf("invalid configuration:\n%w", err)
+		}
+		if fs.Arg(0) == "print" {
+			return printConfig(conf)
+		}
+		fmt.Println("configuration is valid")
+		return nil
+	case "schema":
+		s, err := configSchema()
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
 	}
+	fs.Usage()
+	return fmt.Errorf("unknown config command %q", fs.Arg(0))
+}
 
-	s, err := scheduler.NewScheduler(conf.SchedulerLog, opts...)
-	if err != nil {
-		panic(err)
+func main() {
+	if err := runCommand(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "gosched:", err)
+		os.Exit(1)
 	}
-	s.Start()
 }
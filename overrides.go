@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts environment variables overriding configuration fields,
+// e.g. GOSCHED_SINK_BREAKER_COOL_DOWN or GOSCHED_GROUPING_0_METHOD.
+const envPrefix = "GOSCHED_"
+
+// override sets the field at key, a yaml path with parts separated by dots
+// or underscores and slice entries selected by their index.
+type override struct {
+	source string
+	key    string
+	value  string
+}
+
+// configFlags loads the configuration from the file, environment and flags,
+// in the order of increasing precedence.
+type configFlags struct {
+	file string
+	set  []override
+	// flags named after fields, set with -<path>
+	fields map[string]*string
+	fs     *flag.FlagSet
+}
+
+func newConfigFlags(fs *flag.FlagSet) *configFlags {
+	cf := &configFlags{
+		fs:     fs,
+		fields: make(map[string]*string),
+	}
+	fs.StringVar(&cf.file, "config_file", "", "path to a configuration file")
+	fs.Func("set", "override any field as path=value, e.g. grouping.0.method=notify, can be repeated", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return errors.New("expected path=value")
+		}
+		cf.set = append(cf.set, override{source: "-set " + k, key: k, value: v})
+		return nil
+	})
+	walkFields(reflect.TypeOf(Config{}), "", func(path string, f reflect.StructField) {
+		cf.fields[path] = fs.String(path, "", f.Tag.Get("description"))
+	})
+	return cf
+}
+
+// walkFields calls fn for every field of t that can be set by a flag, slices
+// of structs are left for -set.
+func walkFields(t reflect.Type, prefix string, fn func(path string, f reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, skip := yamlName(f)
+		if skip {
+			continue
+		}
+		path := prefix + name
+
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Kind() == reflect.Struct:
+			walkFields(ft, path+".", fn)
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+		default:
+			fn(path, f)
+		}
+	}
+}
+
+// load returns the validated configuration.
+func (cf *configFlags) load() (Config, error) {
+	conf, err := readConfig(cf.file)
+	if err != nil {
+		return conf, err
+	}
+
+	overrides := envOverrides(os.Environ())
+	cf.fs.Visit(func(f *flag.Flag) {
+		if p, ok := cf.fields[f.Name]; ok {
+			overrides = append(overrides, override{source: "-" + f.Name, key: f.Name, value: *p})
+		}
+	})
+	overrides = append(overrides, cf.set...)
+
+	var errs []error
+	for _, o := range overrides {
+		if err := setField(reflect.ValueOf(&conf).Elem(), normalizeKey(o.key), o.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o.source, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return conf, err
+	}
+	return conf, conf.validate()
+}
+
+// envOverrides returns overrides from GOSCHED_ variables sorted by name.
+func envOverrides(environ []string) []override {
+	var res []override
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(k, envPrefix) {
+			continue
+		}
+		res = append(res, override{
+			source: k,
+			key:    strings.TrimPrefix(k, envPrefix),
+			value:  v,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].key < res[j].key
+	})
+	return res
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, ".", "_"))
+}
+
+// setField sets the field at key in v. Field names contain underscores too,
+// so the longest field name matching the start of the key wins.
+func setField(v reflect.Value, key, value string) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct:
+		var (
+			match reflect.Value
+			rest  string
+			found = -1
+		)
+		for i := 0; i < v.NumField(); i++ {
+			name, skip := yamlName(v.Type().Field(i))
+			if skip || len(name) <= found {
+				continue
+			}
+			switch {
+			case key == name:
+				match, rest, found = v.Field(i), "", len(name)
+			case strings.HasPrefix(key, name+"_"):
+				match, rest, found = v.Field(i), key[len(name)+1:], len(name)
+			}
+		}
+		if found < 0 {
+			return fmt.Errorf("unknown field %q", key)
+		}
+		if rest == "" {
+			return setValue(match, value)
+		}
+		return setField(match, rest, value)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		idx, rest, _ := strings.Cut(key, "_")
+		i, err := strconv.Atoi(idx)
+		if err != nil || i < 0 {
+			return fmt.Errorf("invalid index %q", idx)
+		}
+		for v.Len() <= i {
+			// entries added in between are caught by validation
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+		}
+		if rest == "" {
+			return errors.New("missing field of the entry")
+		}
+		return setField(v.Index(i), rest, value)
+	}
+	return fmt.Errorf("%q doesn't have fields", key)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return errors.New("set the fields of its entries instead")
+		}
+		var items []string
+		if value != "" {
+			items = strings.Split(value, ",")
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("set its fields instead")
+	}
+	return nil
+}
+
+func yamlName(f reflect.StructField) (name string, skip bool) {
+	if !f.IsExported() {
+		return "", true
+	}
+	name, _, _ = strings.Cut(f.Tag.Get("yaml"), ",")
+	switch name {
+	case "-":
+		return "", true
+	case "":
+		name = strings.ToLower(f.Name)
+	}
+	return name, false
+}
+
+// redacted returns the configuration with credentials removed from urls.
+func (c Config) redacted() Config {
+	if u, err := url.Parse(c.SinkAddress); err == nil && u.User != nil {
+		c.SinkAddress = u.Redacted()
+	}
+	return c
+}
+
+// printConfig writes the effective configuration as yaml.
+func printConfig(c Config) error {
+	raw, err := yaml.Marshal(c.redacted())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(raw)
+	return err
+}
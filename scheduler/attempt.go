@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxAttemptResponse is the number of bytes of a response or error kept in the history.
+const maxAttemptResponse = 1024
+
+// Attempt is a single delivery of a task.
+type Attempt struct {
+	TaskId     int
+	Number     int
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Handler    string
+	StatusCode int
+	Response   string
+	Error      string
+}
+
+// Response is what the sink answered to a delivery.
+type Response struct {
+	StatusCode int
+	Body       []byte
+}
+
+// namedHandler is implemented by handlers that describe themselves in the attempt history.
+type namedHandler interface {
+	Name() string
+}
+
+func handlerName(h Handler) string {
+	if n, ok := h.(namedHandler); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+func newAttempt(h Handler, o *outcome) *Attempt {
+	a := &Attempt{
+		TaskId:     o.t.Id,
+		Number:     o.t.Retries + 1,
+		StartedAt:  o.start,
+		FinishedAt: o.end,
+		Handler:    handlerName(h),
+	}
+	if res := o.t.response; res != nil {
+		a.StatusCode = res.StatusCode
+		a.Response = truncate(string(res.Body), maxAttemptResponse)
+	}
+	if o.err != nil {
+		a.Error = truncate(o.err.Error(), maxAttemptResponse)
+	}
+	return a
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -1,43 +1,138 @@
 package scheduler
 
-import "github.com/VictoriaMetrics/fastcache"
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// errGroupBusy is returned by add when the group of the task is
+	// delivered by another batch.
+	errGroupBusy = errors.New("group is delivered by another batch")
+	// errRateExceeded is returned by add when the batch can't deliver the
+	// task within the rate limits.
+	errRateExceeded = errors.New("rate limit exceeded")
+)
 
 type batch struct {
 	tasks    []*Task
 	maxSize  int
-	strategy map[string]GroupingStrategy
-	cache    *fastcache.Cache
-	grouped  chan []byte
+	settings *settings
+	dedup    DedupStore
+	// keys of groups delivered by any batch of the process
+	claims *sync.Map
+	// keys claimed by this batch
+	claimed map[string]bool
+	// tasks waiting for the tasks delivered for their group, by group
+	followers map[string][]*Task
+	// tasks of groups that were already delivered
 	excluded []*Task
+	// delivered tasks by method, bounded by what rate limits allow within
+	// btime after the commit
+	admitted map[string]int
 }
 
-func newBatch(maxSize int, st map[string]GroupingStrategy, c *fastcache.Cache, grouped chan []byte) *batch {
+func newBatch(maxSize int, st *settings, d DedupStore, claims *sync.Map) *batch {
 	return &batch{
-		tasks:    make([]*Task, 0, maxSize),
-		maxSize:  maxSize,
-		strategy: st,
-		cache:    c,
-		grouped:  grouped,
+		tasks:     make([]*Task, 0, maxSize),
+		maxSize:   maxSize,
+		settings:  st,
+		dedup:     d,
+		claims:    claims,
+		claimed:   make(map[string]bool),
+		followers: make(map[string][]*Task),
+		admitted:  make(map[string]int),
 	}
 }
 
-func (b *batch) add(t *Task) {
-	if g, ok := b.strategy[t.Method]; ok {
-		key := t.key(g.Param, g.TimeFormat)
-		if b.cache.Has(key) {
-			b.excluded = append(b.excluded, t)
-			return
-		} else {
-			b.cache.Set(key, nil)
-			b.grouped <- key
+// add appends the task to the batch. Only the first Max tasks of a group are
+// delivered, each of them stores a key of the group together with its result.
+// Until then other tasks of the group follow them and they are marked as
+// grouped only when all of them succeed. The task isn't added when an error
+// is returned.
+func (b *batch) add(t *Task) error {
+	var group, slot string
+	if g, ok := b.settings.strategy[t.Method]; ok && !g.onRegister() {
+		group = g.group(t)
+		var err error
+		slot, err = b.claim(g, group)
+		if err != nil {
+			return err
+		}
+		if slot == "" {
+			if _, ok := b.followers[group]; ok {
+				b.followers[group] = append(b.followers[group], t)
+			} else {
+				b.excluded = append(b.excluded, t)
+			}
+			return nil
+		}
+	}
+	// only delivered tasks count, grouped ones never reach the sink
+	if !b.settings.admits(t, b.admitted[t.Method], len(b.tasks), btime) {
+		b.release(slot)
+		return errRateExceeded
+	}
+	if slot != "" {
+		t.group, t.slot = group, slot
+		if _, ok := b.followers[group]; !ok {
+			b.followers[group] = nil
 		}
 	}
+	b.admitted[t.Method]++
 	b.tasks = append(b.tasks, t)
+	return nil
+}
+
+// claim returns a key of the group that is neither stored nor claimed, or
+// an empty one when there is none.
+func (b *batch) claim(g GroupingStrategy, group string) (string, error) {
+	for _, slot := range g.slots(group) {
+		if b.claimed[slot] {
+			continue
+		}
+		delivered, err := b.dedup.Has(slot)
+		if err != nil {
+			return "", err
+		}
+		if delivered {
+			continue
+		}
+		if _, busy := b.claims.LoadOrStore(slot, struct{}{}); busy {
+			return "", errGroupBusy
+		}
+		b.claimed[slot] = true
+		return slot, nil
+	}
+	return "", nil
 }
 
-func (b *batch) reset() {
+// release gives up a claimed key, so the group can be delivered by another
+// batch.
+func (b *batch) release(slot string) {
+	if slot == "" {
+		return
+	}
+	delete(b.claimed, slot)
+	b.claims.Delete(slot)
+}
+
+// ttl returns how long the group of a delivered task is kept.
+func (b *batch) ttl(t *Task, now time.Time) time.Duration {
+	return b.settings.strategy[t.Method].ttl(t.At, now)
+}
+
+func (b *batch) reset(st *settings) {
+	for slot := range b.claimed {
+		b.claims.Delete(slot)
+	}
+	clear(b.claimed)
+	clear(b.followers)
+	clear(b.admitted)
 	b.tasks = b.tasks[:0]
 	b.excluded = b.excluded[:0]
+	b.settings = st
 }
 
 func (b *batch) size() int {
@@ -0,0 +1,174 @@
+package scheduler
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultCoolDown         = 30 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker when delivery was short-circuited.
+// Tasks failed with it are left for the next scan without consuming retries.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit.
+	FailureThreshold int
+	// CoolDown is the time the circuit stays open before probing the sink.
+	CoolDown time.Duration
+	// HalfOpenRequests is the number of probes allowed while half-open.
+	HalfOpenRequests int
+}
+
+// CircuitBreaker wraps a Handler and stops calling it after repeated failures.
+type CircuitBreaker struct {
+	h      Handler
+	cfg    BreakerConfig
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	probes   int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(h Handler, cfg BreakerConfig, logger *slog.Logger) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.CoolDown <= 0 {
+		cfg.CoolDown = defaultCoolDown
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &CircuitBreaker{
+		h:      h,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+func (cb *CircuitBreaker) Handle(t *Task) error {
+	if !cb.allow() {
+		return ErrCircuitOpen
+	}
+	err := cb.handler().Handle(t)
+	cb.record(err)
+	return err
+}
+
+func (cb *CircuitBreaker) Name() string {
+	return handlerName(cb.handler())
+}
+
+func (cb *CircuitBreaker) handler() Handler {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.h
+}
+
+// setHandler replaces the wrapped handler keeping the state, used when
+// settings are reloaded without changing the sink.
+func (cb *CircuitBreaker) setHandler(h Handler) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.h = h
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.cfg.CoolDown {
+			return false
+		}
+		cb.setState(BreakerHalfOpen)
+		cb.probes = 1
+		return true
+	case BreakerHalfOpen:
+		if cb.probes >= cb.cfg.HalfOpenRequests {
+			return false
+		}
+		cb.probes++
+		return true
+	}
+	return true
+}
+
+func (cb *CircuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// the sink answered, permanent failures are caused by the task itself
+	if err == nil || isPermanent(err) {
+		cb.failures = 0
+		if cb.state != BreakerClosed {
+			cb.setState(BreakerClosed)
+		}
+		return
+	}
+
+	cb.failures++
+	switch cb.state {
+	case BreakerHalfOpen:
+		cb.open()
+	case BreakerClosed:
+		if cb.failures >= cb.cfg.FailureThreshold {
+			cb.open()
+		}
+	}
+}
+
+func (cb *CircuitBreaker) open() {
+	cb.openedAt = time.Now()
+	cb.probes = 0
+	cb.setState(BreakerOpen)
+}
+
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	cb.logger.Info("circuit breaker state changed",
+		slog.String("from", cb.state.String()),
+		slog.String("to", state.String()),
+		slog.Int("failures", cb.failures))
+	cb.state = state
+}
@@ -0,0 +1,191 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	callbackInterval     = 5 * time.Second
+	callbackTimeout      = 10 * time.Second
+	callbackMaxBackoff   = 10 * time.Minute
+	maxCallbackRetries   = 10
+	callbackHandlerLimit = 10
+)
+
+// CallbackEvent is the result of a delivery reported to the producer of a task.
+type CallbackEvent string
+
+const (
+	CallbackSucceeded    CallbackEvent = "succeeded"
+	CallbackFailed       CallbackEvent = "failed"
+	CallbackDeadLettered CallbackEvent = "dead_lettered"
+)
+
+// Callback is a completion event waiting to be sent to the callback url of a task.
+// Callbacks are written in the same transaction as the delivery result and are
+// sent by a separate loop, so they survive restarts and are retried on failure.
+type Callback struct {
+	Id       int
+	TaskId   int
+	Url      string
+	Payload  []byte
+	Attempts int
+	NextAt   time.Time
+}
+
+type callbackPayload struct {
+	TaskId     int               `json:"task_id"`
+	Method     string            `json:"method"`
+	Params     map[string]string `json:"params"`
+	At         time.Time         `json:"at"`
+	Event      CallbackEvent     `json:"event"`
+	Attempt    int               `json:"attempt"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Response   string            `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func newCallback(o *outcome, a *Attempt) (*Callback, error) {
+	event := CallbackFailed
+	switch {
+	case o.err == nil:
+		event = CallbackSucceeded
+	case isPermanent(o.err) || o.t.Retries+1 >= maxRetries:
+		event = CallbackDeadLettered
+	}
+	payload, err := json.Marshal(callbackPayload{
+		TaskId:     o.t.Id,
+		Method:     o.t.Method,
+		Params:     o.t.Parameters,
+		At:         o.t.At,
+		Event:      event,
+		Attempt:    a.Number,
+		StatusCode: a.StatusCode,
+		Response:   a.Response,
+		Error:      a.Error,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Callback{
+		TaskId:  o.t.Id,
+		Url:     o.t.Callback,
+		Payload: payload,
+		NextAt:  o.end,
+	}, nil
+}
+
+type callbackSender struct {
+	db     Database
+	client *http.Client
+	logger *slog.Logger
+	ticker *time.Ticker
+
+	exitChan chan struct{}
+}
+
+func (s *Scheduler) newCallbackSender() *callbackSender {
+	return &callbackSender{
+		db:       s.db,
+		client:   &http.Client{Timeout: callbackTimeout},
+		logger:   s.logger.With(slog.String("component", "callbacks")),
+		ticker:   time.NewTicker(callbackInterval),
+		exitChan: make(chan struct{}),
+	}
+}
+
+func (cs *callbackSender) start() {
+	for {
+		select {
+		case <-cs.ticker.C:
+			cs.sendPending()
+		case <-cs.exitChan:
+			return
+		}
+	}
+}
+
+func (cs *callbackSender) stop() {
+	cs.exitChan <- struct{}{}
+}
+
+func (cs *callbackSender) sendPending() {
+	callbacks, err := cs.db.FindPendingCallbacks(time.Now())
+	if err != nil {
+		cs.logger.Error("error while finding callbacks", slog.Any("err", err))
+		return
+	}
+
+	var errg errgroup.Group
+	errg.SetLimit(callbackHandlerLimit)
+	for _, cb := range callbacks {
+		errg.Go(func() error {
+			cs.handle(cb)
+			return nil
+		})
+	}
+	_ = errg.Wait()
+}
+
+func (cs *callbackSender) handle(cb *Callback) {
+	err := cs.send(cb)
+	if err == nil {
+		if _, err := cs.db.CompleteCallback(cb.Id); err != nil {
+			cs.logger.Error("error while completing callback", slog.Any("err", err))
+		}
+		return
+	}
+
+	cs.logger.Error("error while sending callback",
+		slog.Int("callback", cb.Id),
+		slog.Int("task", cb.TaskId),
+		slog.Int("attempts", cb.Attempts+1),
+		slog.Any("err", err))
+
+	if cb.Attempts+1 >= maxCallbackRetries {
+		_, err = cs.db.FailCallback(cb.Id)
+	} else {
+		_, err = cs.db.RetryCallback(cb.Id, time.Now().Add(callbackBackoff(cb.Attempts)))
+	}
+	if err != nil {
+		cs.logger.Error("error while updating callback", slog.Any("err", err))
+	}
+}
+
+func (cs *callbackSender) send(cb *Callback) error {
+	ctx, cancel := context.WithTimeout(context.Background(), callbackTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cb.Url, bytes.NewReader(cb.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := cs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("invalid status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// callbackBackoff doubles the delay with every attempt, starting with a second.
+func callbackBackoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return callbackMaxBackoff
+	}
+	return min(time.Second<<attempts, callbackMaxBackoff)
+}
@@ -2,9 +2,21 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+// ErrTaskNotFound is returned by Database when there is no task with the given id.
+var ErrTaskNotFound = errors.New("task not found")
+
+// TaskFilter selects tasks returned by ListTasks, empty fields match every task.
+type TaskFilter struct {
+	Method string
+	Status *TaskStatus
+	Limit  int
+	Offset int
+}
+
 type Iterator interface {
 	Next() bool
 	Scan(...any) error
@@ -15,6 +27,7 @@ type Iterator interface {
 
 type Result interface {
 	LastInsertId() (int64, error)
+	RowsAffected() (int64, error)
 }
 
 type Transaction interface {
@@ -23,11 +36,50 @@ type Transaction interface {
 	CompleteTask(id any) (Result, error)
 	InsertTask(*Task) (Result, error)
 	IncrementRetries(id any) (Result, error)
+	// RetryTask increments retries and postpones the task to the given time.
+	RetryTask(id any, at time.Time) (Result, error)
+	// FailTask marks the task as failed permanently, it won't be found again.
+	FailTask(id any) (Result, error)
+	InsertAttempt(*Attempt) (Result, error)
+	InsertCallback(*Callback) (Result, error)
 }
 
 type Database interface {
 	FindNotCompleted(time.Time) (Iterator, error)
 	Begin(context.Context) (Transaction, error)
-	InsertProcessed(any) (Result, error)
-	GetProcessed() (Iterator, error)
+	Ping(context.Context) error
+	GetTask(id any) (*Task, error)
+	// FindIdempotent returns the id of the task registered with the
+	// idempotency key or ErrTaskNotFound.
+	FindIdempotent(key string) (int, error)
+	// FindGroup returns the id of a pending task of the group due after the
+	// given time or ErrTaskNotFound.
+	FindGroup(key string, after time.Time) (int, error)
+	// MergeTask appends the entry to the digest of a pending task due after
+	// the given time, it affects no rows for other tasks.
+	MergeTask(id any, e DigestEntry, after time.Time) (Result, error)
+	// ReplaceTask overwrites parameters, time, trace, callback and labels of a
+	// pending task due after the given time, it affects no rows for other
+	// tasks.
+	ReplaceTask(id any, t *Task, after time.Time) (Result, error)
+	// LastCompleted returns the time of the latest completed task of the
+	// group, it is zero when there is none.
+	LastCompleted(key string) (time.Time, error)
+	// ListTasks returns tasks ordered by id.
+	ListTasks(TaskFilter) ([]*Task, error)
+	// CancelTask marks a pending task as cancelled, it affects no rows when
+	// the task isn't pending.
+	CancelTask(id any) (Result, error)
+	// RequeueTask makes a failed or cancelled task pending again with reset
+	// retries, it affects no rows for tasks in other states.
+	RequeueTask(id any, at time.Time) (Result, error)
+	// CountTasks returns the number of tasks in every status.
+	CountTasks() (map[TaskStatus]int, error)
+	// GetAttempts returns delivery attempts of the task in order.
+	GetAttempts(id any) ([]*Attempt, error)
+	// FindPendingCallbacks returns callbacks that should be sent before the given time.
+	FindPendingCallbacks(time.Time) ([]*Callback, error)
+	CompleteCallback(id any) (Result, error)
+	RetryCallback(id any, at time.Time) (Result, error)
+	FailCallback(id any) (Result, error)
 }
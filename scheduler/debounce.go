@@ -0,0 +1,23 @@
+package scheduler
+
+import "time"
+
+// debounce postpones the task by the quiet period, but not before the window
+// following the last delivery of its group has passed, and replaces the
+// pending task of the group with it.
+func (s *Scheduler) debounce(g GroupingStrategy, t *Task) (bool, error) {
+	t.GroupKey = g.group(t)
+	t.At = t.At.Add(g.Quiet)
+	if g.Window > 0 {
+		start := time.Now()
+		last, err := s.db.LastCompleted(t.GroupKey)
+		s.metrics.observeQuery("last_completed", start)
+		if err != nil {
+			return false, err
+		}
+		if next := last.Add(g.Window); next.After(t.At) {
+			t.At = next
+		}
+	}
+	return s.replacePending(t, false)
+}
@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	compactInterval = time.Hour
+	// compactChunk bounds the keys deleted at once, so deliveries aren't
+	// blocked by a long delete
+	compactChunk = 1000
+	compactPause = 100 * time.Millisecond
+)
+
+// DedupStore remembers grouping keys of tasks that were already delivered.
+// A store shared by several schedulers, like the database, keeps grouping
+// correct across restarts and instances.
+//
+// When the store is the database and its transactions implement DedupStore
+// too, keys are stored by the transaction recording the delivery, so a key
+// is stored if and only if its task was delivered. Other stores are updated
+// after the transaction is committed.
+type DedupStore interface {
+	// Has reports whether the key is stored and not expired.
+	Has(key string) (bool, error)
+	// SetIfAbsent stores the key for ttl and reports whether it was missing
+	// or expired. The key never expires when ttl is 0.
+	SetIfAbsent(key string, ttl time.Duration) (bool, error)
+}
+
+// DedupCompactor is implemented by stores that keep expired keys until they
+// are deleted, the scheduler deletes them periodically.
+type DedupCompactor interface {
+	// DeleteExpired deletes at most limit keys expired before the given time
+	// and returns their number.
+	DeleteExpired(before time.Time, limit int) (int, error)
+}
+
+// WithDedupStore sets the store of grouping keys. By default the database is
+// used when it implements DedupStore, otherwise keys are kept in memory.
+func WithDedupStore(d DedupStore) Option {
+	return func(s *Scheduler) {
+		s.dedup = d
+	}
+}
+
+// txDedup reports whether grouping keys are stored by the transactions
+// recording deliveries, that is the store is the database.
+func (s *Scheduler) txDedup() bool {
+	return any(s.dedup) == any(s.db)
+}
+
+// memoryDedup keeps keys in memory, they are lost on restart and aren't
+// shared with other instances.
+type memoryDedup struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+	// sets since expired keys were last removed
+	sets int
+}
+
+// purgeEvery is the number of sets after which expired keys are removed.
+const purgeEvery = 1024
+
+// NewMemoryDedupStore returns a DedupStore keeping keys in memory.
+func NewMemoryDedupStore() DedupStore {
+	return &memoryDedup{
+		keys: make(map[string]time.Time),
+	}
+}
+
+func (d *memoryDedup) Has(key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	exp, ok := d.keys[key]
+	return ok && (exp.IsZero() || time.Now().Before(exp)), nil
+}
+
+func (d *memoryDedup) SetIfAbsent(key string, ttl time.Duration) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if exp, ok := d.keys[key]; ok && (exp.IsZero() || now.Before(exp)) {
+		return false, nil
+	}
+
+	d.sets++
+	if d.sets >= purgeEvery {
+		d.sets = 0
+		for k, exp := range d.keys {
+			if !exp.IsZero() && !now.Before(exp) {
+				delete(d.keys, k)
+			}
+		}
+	}
+
+	var exp time.Time
+	if ttl > 0 {
+		exp = now.Add(ttl)
+	}
+	d.keys[key] = exp
+	return true, nil
+}
+
+type compactor struct {
+	store   DedupCompactor
+	logger  *slog.Logger
+	metrics *metrics
+	ticker  *time.Ticker
+
+	exitChan chan struct{}
+}
+
+// newCompactor returns nil when the store doesn't need compaction.
+func (s *Scheduler) newCompactor() *compactor {
+	c, ok := s.dedup.(DedupCompactor)
+	if !ok {
+		return nil
+	}
+	return &compactor{
+		store:    c,
+		logger:   s.logger.With(slog.String("component", "compactor")),
+		metrics:  s.metrics,
+		ticker:   time.NewTicker(compactInterval),
+		exitChan: make(chan struct{}),
+	}
+}
+
+func (c *compactor) start() {
+	if !c.compact() {
+		return
+	}
+	for {
+		select {
+		case <-c.ticker.C:
+			if !c.compact() {
+				return
+			}
+		case <-c.exitChan:
+			return
+		}
+	}
+}
+
+func (c *compactor) stop() {
+	c.exitChan <- struct{}{}
+}
+
+// compact deletes expired keys in chunks until there are none left. It
+// returns false when the compactor was stopped meanwhile.
+func (c *compactor) compact() bool {
+	now := time.Now()
+	var total int
+	for {
+		start := time.Now()
+		n, err := c.store.DeleteExpired(now, compactChunk)
+		c.metrics.observeQuery("delete_expired", start)
+		if err != nil {
+			c.logger.Error("error while deleting expired keys", slog.Any("err", err))
+			return true
+		}
+		total += n
+		c.metrics.compacted.Add(float64(n))
+		if n < compactChunk {
+			break
+		}
+		select {
+		case <-time.After(compactPause):
+		case <-c.exitChan:
+			return false
+		}
+	}
+	c.logger.Debug("deleted expired keys", slog.Int("keys", total))
+	return true
+}
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Digest lists tasks merged into one delivery by a GroupingMerge strategy, in
+// the order they were registered.
+type Digest []DigestEntry
+
+// DigestEntry is a merged task.
+type DigestEntry struct {
+	Params map[string]string `json:"params"`
+	At     time.Time         `json:"at"`
+}
+
+// First returns the earliest time of the merged tasks.
+func (d Digest) First() time.Time {
+	var first time.Time
+	for i, e := range d {
+		if i == 0 || e.At.Before(first) {
+			first = e.At
+		}
+	}
+	return first
+}
+
+// Last returns the latest time of the merged tasks.
+func (d Digest) Last() time.Time {
+	var last time.Time
+	for _, e := range d {
+		if e.At.After(last) {
+			last = e.At
+		}
+	}
+	return last
+}
+
+// payload returns the body sent to the http sink.
+func (d Digest) payload() ([]byte, error) {
+	params := make([]map[string]string, len(d))
+	for i, e := range d {
+		params[i] = e.Params
+	}
+	return json.Marshal(struct {
+		Count  int                 `json:"count"`
+		First  time.Time           `json:"first"`
+		Last   time.Time           `json:"last"`
+		Params []map[string]string `json:"params"`
+	}{
+		Count:  len(d),
+		First:  d.First(),
+		Last:   d.Last(),
+		Params: params,
+	})
+}
+
+// merge appends the task to the digest of its group while the window of the
+// group is open. Otherwise the task opens a new window, it is delivered with
+// its digest when the window closes.
+func (s *Scheduler) merge(g GroupingStrategy, t *Task) (bool, error) {
+	t.GroupKey = g.group(t)
+	entry := DigestEntry{Params: t.Parameters, At: t.At}
+
+	// the digest mustn't be delivered yet
+	open := time.Now()
+	if t.At.After(open) {
+		open = t.At
+	}
+
+	start := time.Now()
+	id, err := s.db.FindGroup(t.GroupKey, open)
+	s.metrics.observeQuery("find_group", start)
+	switch {
+	case err == nil:
+		start = time.Now()
+		res, err := s.db.MergeTask(id, entry, open)
+		s.metrics.observeQuery("merge_task", start)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			s.logger.Debug("merged task",
+				slog.Int("id", id), slog.String("group", t.GroupKey))
+			s.metrics.grouped.WithLabelValues(t.Method).Inc()
+			t.Id = id
+			return true, nil
+		}
+		// the window closed meanwhile
+	case !errors.Is(err, ErrTaskNotFound):
+		return false, err
+	}
+
+	t.Digest = Digest{entry}
+	t.At = g.closes(t.At)
+	return false, nil
+}
@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Permanent marks a delivery failure that will not succeed on retry, e.g. a
+// malformed task rejected by the sink. The task is failed without retries.
+type Permanent struct {
+	Err error
+}
+
+func (e *Permanent) Error() string {
+	return fmt.Sprintf("permanent: %v", e.Err)
+}
+
+func (e *Permanent) Unwrap() error {
+	return e.Err
+}
+
+// Retryable marks a delivery failure that may succeed later. When After is set
+// the next attempt is not made before it passes.
+type Retryable struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *Retryable) Error() string {
+	if e.After > 0 {
+		return fmt.Sprintf("retryable after %s: %v", e.After, e.Err)
+	}
+	return fmt.Sprintf("retryable: %v", e.Err)
+}
+
+func (e *Retryable) Unwrap() error {
+	return e.Err
+}
+
+func isPermanent(err error) bool {
+	var perr *Permanent
+	return errors.As(err, &perr)
+}
+
+// retryAfter returns the delay requested by a Retryable error.
+func retryAfter(err error) time.Duration {
+	var rerr *Retryable
+	if errors.As(err, &rerr) {
+		return rerr.After
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventBufferSize is the number of events buffered per subscriber. Events
+// published to a full subscriber are dropped instead of blocking delivery.
+const eventBufferSize = 256
+
+type EventType string
+
+const (
+	EventRegistered EventType = "registered"
+	EventDispatched EventType = "dispatched"
+	EventSucceeded  EventType = "succeeded"
+	// EventFailed is published when the task won't be delivered, it failed
+	// permanently or ran out of retries.
+	EventFailed EventType = "failed"
+	// EventRetried is published when an attempt failed and the task will be retried.
+	EventRetried   EventType = "retried"
+	EventGrouped   EventType = "grouped"
+	EventCancelled EventType = "cancelled"
+)
+
+// Event describes a change in the lifecycle of a task.
+type Event struct {
+	Type   EventType
+	TaskId int
+	Method string
+	Labels map[string]string
+	At     time.Time
+	Time   time.Time
+	Error  string
+}
+
+func newEvent(typ EventType, t *Task) Event {
+	return Event{
+		Type:   typ,
+		TaskId: t.Id,
+		Method: t.Method,
+		Labels: t.Labels,
+		At:     t.At,
+		Time:   time.Now(),
+	}
+}
+
+// EventFilter selects events of a subscription. Empty fields match every event,
+// all labels of the filter have to be present on the task.
+type EventFilter struct {
+	Method string
+	TaskId int
+	Labels map[string]string
+}
+
+func (f EventFilter) match(e Event) bool {
+	if f.Method != "" && f.Method != e.Method {
+		return false
+	}
+	if f.TaskId != 0 && f.TaskId != e.TaskId {
+		return false
+	}
+	for k, v := range f.Labels {
+		if e.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type subscription struct {
+	ch     chan Event
+	filter EventFilter
+}
+
+// eventBus fans lifecycle events out to subscribers.
+type eventBus struct {
+	mu      sync.RWMutex
+	subs    map[*subscription]struct{}
+	dropped atomic.Uint64
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs: make(map[*subscription]struct{}),
+	}
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if !sub.filter.match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			b.dropped.Add(1)
+		}
+	}
+}
+
+func (b *eventBus) subscribe(f EventFilter) *subscription {
+	sub := &subscription{
+		ch:     make(chan Event, eventBufferSize),
+		filter: f,
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *eventBus) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+}
+
+// Subscribe returns events matching the filter until cancel is called.
+func (s *Scheduler) Subscribe(f EventFilter) (<-chan Event, func()) {
+	sub := s.events.subscribe(f)
+	return sub.ch, func() {
+		s.events.unsubscribe(sub)
+	}
+}
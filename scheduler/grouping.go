@@ -0,0 +1,223 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// GroupingKind selects how windows of a GroupingStrategy are formed.
+type GroupingKind string
+
+const (
+	// GroupingFixed groups tasks whose delivery time formatted with
+	// TimeFormat is the same, i.e. within calendar aligned windows.
+	GroupingFixed GroupingKind = "fixed"
+	// GroupingRolling groups tasks delivered within Window after a delivery
+	// of the group.
+	GroupingRolling GroupingKind = "rolling"
+)
+
+// GroupingMode selects what happens to tasks of a group in a window.
+type GroupingMode string
+
+const (
+	// GroupingFirst delivers the first Max tasks of a window and marks the
+	// others as grouped.
+	GroupingFirst GroupingMode = "first"
+	// GroupingMerge merges tasks of a window into one Digest delivered when
+	// the window closes.
+	GroupingMerge GroupingMode = "merge"
+	// GroupingReplace replaces a pending task of the group with the one
+	// registered last.
+	GroupingReplace GroupingMode = "replace"
+	// GroupingDebounce delivers the task registered last, Quiet after its
+	// time and at least Window after the previous delivery of the group.
+	GroupingDebounce GroupingMode = "debounce"
+	// GroupingThrottle delivers the first task of the group and marks the
+	// ones due within Window after it as grouped. Kind is ignored.
+	GroupingThrottle GroupingMode = "throttle"
+)
+
+// GroupingStrategy groups tasks of a method with the same values of Param
+// within windows.
+type GroupingStrategy struct {
+	Method string
+	// Kind is GroupingFixed when empty.
+	Kind GroupingKind
+	// Mode is GroupingFirst when empty.
+	Mode GroupingMode
+	// TimeFormat is the layout of fixed windows.
+	TimeFormat string
+	// Window is the duration of rolling windows.
+	Window time.Duration
+	// Quiet is the time GroupingDebounce waits for a newer task.
+	Quiet time.Duration
+	// Max is the number of tasks delivered per window by GroupingFirst, 1
+	// when 0.
+	Max int
+	// KeepEarliest keeps the time of the replaced task when it is earlier,
+	// used by GroupingReplace.
+	KeepEarliest bool
+	Param        []string
+}
+
+// Validate returns all problems of the strategy joined together.
+func (g GroupingStrategy) Validate() error {
+	var errs []error
+	switch g.Mode {
+	case GroupingDebounce:
+		if g.Quiet <= 0 {
+			errs = append(errs, errors.New("quiet period must be positive"))
+		}
+		if g.Window < 0 {
+			errs = append(errs, errors.New("negative window"))
+		}
+		return errors.Join(errs...)
+	case GroupingThrottle:
+		if g.Window <= 0 {
+			errs = append(errs, errors.New("window must be positive"))
+		}
+		if g.Max < 0 {
+			errs = append(errs, errors.New("negative max"))
+		}
+		return errors.Join(errs...)
+	}
+
+	switch g.Kind {
+	case "", GroupingFixed:
+		if g.TimeFormat == "" {
+			errs = append(errs, errors.New("empty time format"))
+		}
+	case GroupingRolling:
+		if g.Window <= 0 {
+			errs = append(errs, errors.New("window must be positive"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported kind %q", g.Kind))
+	}
+	switch g.Mode {
+	case "", GroupingFirst, GroupingReplace:
+	case GroupingMerge:
+		if _, ok := windowOf(g.TimeFormat); !ok && g.Kind != GroupingRolling {
+			errs = append(errs, errors.New("time format of merged tasks must depend on the time"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported mode %q", g.Mode))
+	}
+	if g.Max < 0 {
+		errs = append(errs, errors.New("negative max"))
+	}
+	return errors.Join(errs...)
+}
+
+// onRegister reports whether tasks are grouped when they are registered
+// instead of when they are delivered.
+func (g GroupingStrategy) onRegister() bool {
+	return g.Mode == GroupingMerge || g.Mode == GroupingReplace || g.Mode == GroupingDebounce
+}
+
+// rolling reports whether windows start with a delivery.
+func (g GroupingStrategy) rolling() bool {
+	return g.Kind == GroupingRolling || g.Mode == GroupingThrottle
+}
+
+// group returns the key shared by tasks of the same window, debounced tasks
+// share it regardless of the time.
+func (g GroupingStrategy) group(t *Task) string {
+	if g.rolling() || g.Mode == GroupingDebounce {
+		return string(t.key(g.Param, ""))
+	}
+	return string(t.key(g.Param, g.TimeFormat))
+}
+
+// slots returns keys stored for the tasks delivered in the window of the
+// group, one for each of the Max tasks.
+func (g GroupingStrategy) slots(group string) []string {
+	if g.Max <= 1 {
+		return []string{group}
+	}
+	slots := make([]string, g.Max)
+	for i := range slots {
+		slots[i] = group + "#" + strconv.Itoa(i)
+	}
+	return slots
+}
+
+// keyGrace is how long a grouping key is kept after its window closes, so
+// tasks of the window delivered late are still grouped.
+const keyGrace = 24 * time.Hour
+
+// windowUnit is the finest part of the time a TimeFormat keeps, tasks are
+// grouped within windows of one unit.
+type windowUnit int
+
+const (
+	unitSecond windowUnit = iota
+	unitMinute
+	unitHour
+	unitDay
+	unitMonth
+	unitYear
+)
+
+// windowOf returns the unit of the layout, there is none when the layout
+// doesn't depend on the time.
+func windowOf(layout string) (windowUnit, bool) {
+	ref := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+	for u := unitSecond; u <= unitYear; u++ {
+		if ref.Format(layout) != u.next(ref).Format(layout) {
+			return u, true
+		}
+	}
+	return 0, false
+}
+
+// next returns the start of the window following the one of t.
+func (u windowUnit) next(t time.Time) time.Time {
+	y, mo, d := t.Date()
+	h, mi, s := t.Clock()
+	switch u {
+	case unitSecond:
+		return time.Date(y, mo, d, h, mi, s+1, 0, t.Location())
+	case unitMinute:
+		return time.Date(y, mo, d, h, mi+1, 0, 0, t.Location())
+	case unitHour:
+		return time.Date(y, mo, d, h+1, 0, 0, 0, t.Location())
+	case unitDay:
+		return time.Date(y, mo, d+1, 0, 0, 0, 0, t.Location())
+	case unitMonth:
+		return time.Date(y, mo+1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y+1, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// ttl returns how long the grouping key of a task delivered at now is kept.
+// Rolling windows start with the delivery. Fixed windows are kept until the
+// window of at closes, plus keyGrace, and forever (0) when the TimeFormat
+// doesn't depend on the time.
+func (g GroupingStrategy) ttl(at, now time.Time) time.Duration {
+	if g.rolling() {
+		return g.Window
+	}
+	u, ok := windowOf(g.TimeFormat)
+	if !ok {
+		return 0
+	}
+	return max(u.next(at).Sub(now), 0) + keyGrace
+}
+
+// closes returns when the window of a task at closes. Rolling windows start
+// with the task.
+func (g GroupingStrategy) closes(at time.Time) time.Time {
+	if g.rolling() {
+		return at.Add(g.Window)
+	}
+	u, ok := windowOf(g.TimeFormat)
+	if !ok {
+		return at
+	}
+	return u.next(at)
+}
@@ -1,12 +1,17 @@
 package scheduler
 
 import (
-	"errors"
+	"bytes"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type Handler interface {
@@ -33,6 +38,10 @@ type HttpHandler struct {
 	logger *slog.Logger
 }
 
+func (h *HttpHandler) Name() string {
+	return "http " + h.addr
+}
+
 func (h *HttpHandler) Handle(t *Task) error {
 	uri, err := url.JoinPath(h.addr, t.Method)
 	if err != nil {
@@ -43,10 +52,23 @@ func (h *HttpHandler) Handle(t *Task) error {
 		slog.String("method", t.Method),
 		slog.Any("params", t.Parameters))
 
-	req, err := http.NewRequest("GET", uri, nil)
+	// a digest is sent in the body, the query has parameters of its first task
+	method, body := "GET", io.Reader(nil)
+	if len(t.Digest) > 0 {
+		payload, err := t.Digest.payload()
+		if err != nil {
+			return &Permanent{Err: err}
+		}
+		method, body = "POST", bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(t.Context(), method, uri, body)
 	if err != nil {
 		return err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	q := req.URL.Query()
 	for k, v := range t.Parameters {
@@ -54,28 +76,68 @@ func (h *HttpHandler) Handle(t *Task) error {
 	}
 	req.URL.RawQuery = q.Encode()
 	reqUrl := req.URL.String()
-	res, err := http.Get(reqUrl)
+	InjectTrace(t, propagation.HeaderCarrier(req.Header))
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return &Retryable{Err: err}
 	}
+	defer res.Body.Close()
 
 	h.logger.Debug("sending message", slog.String("uri", reqUrl))
 
-	if res.StatusCode > 201 {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
 		body, _ := io.ReadAll(res.Body)
+		t.SetResponse(res.StatusCode, body)
 		h.logger.Error("invalid status code",
 			slog.Int("status_code", res.StatusCode),
 			slog.String("response", string(body)))
-		return errors.New("invalid status code")
+		return classifyStatus(res)
 	}
 
+	// the sink accepted the task, a broken body mustn't deliver it again
 	resp, err := io.ReadAll(res.Body)
 	if err != nil {
-		h.logger.Error("couldnt read whole response body")
-		return err
+		h.logger.Error("couldnt read whole response body", slog.Any("err", err))
 	}
+	t.SetResponse(res.StatusCode, resp)
 
-	// todo: maybe notify sender if option was provided
 	slog.Debug("got response", slog.String("body", string(resp)))
 	return nil
 }
+
+// classifyStatus maps a non 2xx response to a handler error. Client errors are
+// permanent, except for timeouts and throttling which are retried together
+// with server errors, honoring the Retry-After header.
+func classifyStatus(res *http.Response) error {
+	err := fmt.Errorf("invalid status code %d", res.StatusCode)
+	switch {
+	case res.StatusCode == http.StatusRequestTimeout,
+		res.StatusCode == http.StatusTooManyRequests,
+		res.StatusCode >= 500:
+		return &Retryable{
+			Err:   err,
+			After: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+		}
+	default:
+		return &Permanent{Err: err}
+	}
+}
+
+// parseRetryAfter accepts both forms of Retry-After: delay in seconds and an http date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	healthCheckTimeout = time.Second
+	// scans missed before the worker loop is considered stuck
+	missedScans = 3
+)
+
+// ComponentStatus is the health of a single part of the scheduler.
+type ComponentStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+type health struct {
+	db        Database
+	m         *workerManager
+	breaker   func() BreakerState
+	listening atomic.Bool
+}
+
+// check returns status of the database, the worker loop, the listener and the sink.
+func (h *health) check(ctx context.Context) []ComponentStatus {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	res := make([]ComponentStatus, 0, 4)
+
+	db := ComponentStatus{Name: "database", Healthy: true}
+	if err := h.db.Ping(ctx); err != nil {
+		db.Healthy = false
+		db.Message = err.Error()
+	}
+	res = append(res, db)
+
+	worker := ComponentStatus{Name: "worker", Healthy: true}
+	if age, maxAge := time.Since(h.m.lastTick()), missedScans*h.m.ttime; age > maxAge {
+		worker.Healthy = false
+		worker.Message = fmt.Sprintf("last scan %s ago", age.Round(time.Second))
+	}
+	res = append(res, worker)
+
+	listener := ComponentStatus{Name: "listener", Healthy: h.listening.Load()}
+	if !listener.Healthy {
+		listener.Message = "not listening"
+	}
+	res = append(res, listener)
+
+	sink := ComponentStatus{Name: "sink", Healthy: true}
+	if state := h.breaker(); state == BreakerOpen {
+		sink.Healthy = false
+		sink.Message = "circuit breaker is " + state.String()
+	}
+	res = append(res, sink)
+
+	return res
+}
+
+func healthy(components []ComponentStatus) bool {
+	for _, c := range components {
+		if !c.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// livenessHandler reports that the process is alive.
+func (h *health) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// readinessHandler reports whether the scheduler can register and deliver tasks.
+func (h *health) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	components := h.check(r.Context())
+	status := http.StatusOK
+	if !healthy(components) {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Healthy    bool              `json:"healthy"`
+		Components []ComponentStatus `json:"components"`
+	}{
+		Healthy:    status == http.StatusOK,
+		Components: components,
+	})
+}
@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limit bounds delivery of tasks. Zero values mean no limit.
+type Limit struct {
+	// MaxInFlight is the maximum number of deliveries handled at once.
+	MaxInFlight int
+	// Rate is the number of deliveries allowed per second.
+	Rate float64
+	// Burst is the size of the token bucket, defaults to 1 when Rate is set.
+	Burst int
+}
+
+type limiter struct {
+	cfg Limit
+	sem chan struct{}
+	rl  *rate.Limiter
+}
+
+func newLimiter(l Limit) *limiter {
+	lim := &limiter{cfg: l}
+	if l.MaxInFlight > 0 {
+		lim.sem = make(chan struct{}, l.MaxInFlight)
+	}
+	if l.Rate > 0 {
+		burst := l.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		lim.rl = rate.NewLimiter(rate.Limit(l.Rate), burst)
+	}
+	return lim
+}
+
+// reuseLimiter returns prev when its limit is l, so reloaded settings share
+// tokens and in-flight slots with batches started before.
+func reuseLimiter(prev *limiter, l Limit) *limiter {
+	if prev != nil && prev.cfg == l {
+		return prev
+	}
+	return newLimiter(l)
+}
+
+func newLimiters(m map[string]Limit, prev map[string]*limiter) map[string]*limiter {
+	res := make(map[string]*limiter, len(m))
+	for method, l := range m {
+		res[method] = reuseLimiter(prev[method], l)
+	}
+	return res
+}
+
+// admits reports whether a delivery following n others can get a token
+// within d, when the bucket starts full.
+func (l *limiter) admits(n int, d time.Duration) bool {
+	if l == nil || l.rl == nil {
+		return true
+	}
+	return n < l.rl.Burst()+int(float64(l.rl.Limit())*d.Seconds())
+}
+
+// wait takes a token from the bucket, waiting until there is one.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil || l.rl == nil {
+		return nil
+	}
+	return l.rl.Wait(ctx)
+}
+
+// tryAcquire takes an in-flight slot if one is free.
+func (l *limiter) tryAcquire() bool {
+	if l == nil || l.sem == nil {
+		return true
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *limiter) release() {
+	if l == nil || l.sem == nil {
+		return
+	}
+	<-l.sem
+}
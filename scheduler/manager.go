@@ -0,0 +1,265 @@
+package scheduler
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultWorkers    = 1
+	workerQueueFactor = 2
+)
+
+// Partition decides which worker receives a task.
+type Partition int
+
+const (
+	// PartitionByMethod keeps every task of a method on the same worker, so
+	// grouping keys (which are prefixed by the method) never race between workers.
+	PartitionByMethod Partition = iota
+	// PartitionById spreads tasks evenly regardless of their method.
+	PartitionById
+)
+
+// workerManager scans the database and fans found tasks out to its workers.
+type workerManager struct {
+	db      Database
+	metrics *metrics
+	tracer  trace.Tracer
+	events  *eventBus
+
+	workers   []*worker
+	partition Partition
+
+	ticker   *time.Ticker
+	ttime    time.Duration
+	exitChan chan struct{}
+	logger   *slog.Logger
+
+	// ids of tasks handed to a worker and not yet committed
+	inflight sync.Map
+	// grouping keys of tasks handed to a worker and not yet committed
+	claims sync.Map
+	// unix nano time of the last scan
+	tick atomic.Int64
+}
+
+func (s *Scheduler) newWorkerManager() (*workerManager, error) {
+	m := &workerManager{
+		db:        s.db,
+		metrics:   s.metrics,
+		tracer:    s.tracer,
+		events:    s.events,
+		partition: s.opts.partition,
+		exitChan:  make(chan struct{}),
+		logger:    s.logger,
+	}
+	if s.opts.ticker != nil {
+		m.ttime = *s.opts.ticker
+	} else {
+		m.ttime = ttime
+	}
+
+	n := s.opts.workers
+	if n <= 0 {
+		n = defaultWorkers
+	}
+	for i := 0; i < n; i++ {
+		m.workers = append(m.workers, s.newWorker(i, m))
+	}
+
+	m.ticker = time.NewTicker(m.ttime)
+	return m, nil
+}
+
+func (m *workerManager) findTasks() ([]*Task, error) {
+	tt := time.Now()
+	defer m.metrics.observeQuery("find_not_completed", tt)
+
+	ctx, span := m.tracer.Start(context.Background(), "worker.findTasks")
+	defer span.End()
+
+	res, err := m.db.FindNotCompleted(tt)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	defer func() {
+		err = res.Close()
+		if err != nil {
+			m.logger.Error("error while closing iterator", slog.Any("error", err))
+		}
+	}()
+
+	var (
+		tasks []*Task
+		// ran out of retries before they were marked as failed
+		exhausted []int
+	)
+
+	for res.Next() {
+		t := EmptyTask()
+		err := res.Into(t)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Retries >= maxRetries {
+			exhausted = append(exhausted, t.Id)
+			t.Dispose()
+			continue
+		}
+
+		tasks = append(tasks, t)
+	}
+
+	if err = res.Err(); err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	if len(exhausted) > 0 {
+		if err := m.failExhausted(ctx, exhausted); err != nil {
+			m.logger.Error("couldn't mark tasks out of retries as failed",
+				slog.Any("tasks", exhausted),
+				slog.Any("err", err))
+		}
+	}
+
+	span.SetAttributes(attribute.Int("tasks", len(tasks)))
+	return tasks, nil
+}
+
+// failExhausted marks tasks that ran out of retries as failed, so they are
+// listed in the dead letter queue and can be retried.
+func (m *workerManager) failExhausted(ctx context.Context, ids []int) error {
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := tx.FailTask(id); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.logger.Error("max retries exceeded, tasks marked as failed", slog.Any("tasks", ids))
+	return nil
+}
+
+func (m *workerManager) start() {
+	for _, w := range m.workers {
+		go w.start()
+	}
+	m.tick.Store(time.Now().UnixNano())
+	for {
+		select {
+		case <-m.ticker.C:
+			m.tick.Store(time.Now().UnixNano())
+			tt, err := m.findTasks()
+			if err != nil {
+				m.logger.Error("error while finding tasks", slog.Any("err", err))
+				m.ticker.Reset(m.ttime)
+				continue
+			}
+			m.logger.Debug("found some tasks", slog.Int("number_of_tasks", len(tt)))
+			m.metrics.pending.Set(float64(len(tt)))
+			m.dispatch(tt)
+		case <-m.exitChan:
+			for _, w := range m.workers {
+				w.exitChan <- struct{}{}
+			}
+			return
+		}
+	}
+}
+
+// lastTick returns time of the last scan, or of the start when there was none.
+func (m *workerManager) lastTick() time.Time {
+	return time.Unix(0, m.tick.Load())
+}
+
+func (m *workerManager) stop() {
+	m.exitChan <- struct{}{}
+}
+
+// dispatch hands tasks to their workers without blocking. Tasks that are
+// still in flight from a previous scan, or whose worker queue is full, are
+// left for the next scan.
+func (m *workerManager) dispatch(tasks []*Task) {
+	var skipped int
+	for _, t := range tasks {
+		if _, loaded := m.inflight.LoadOrStore(t.Id, struct{}{}); loaded {
+			t.Dispose()
+			continue
+		}
+		w := m.workerFor(t)
+		select {
+		case w.tasks <- t:
+		default:
+			m.inflight.Delete(t.Id)
+			m.metrics.deferred.WithLabelValues(t.Method).Inc()
+			t.Dispose()
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		m.logger.Debug("worker queues full, tasks left for next scan",
+			slog.Int("skipped", skipped))
+	}
+}
+
+func (m *workerManager) done(id int) {
+	m.inflight.Delete(id)
+}
+
+func (m *workerManager) workerFor(t *Task) *worker {
+	if len(m.workers) == 1 {
+		return m.workers[0]
+	}
+	h := fnv.New32a()
+	switch m.partition {
+	case PartitionById:
+		h.Write([]byte(strconv.Itoa(t.Id)))
+	default:
+		h.Write([]byte(t.Method))
+	}
+	return m.workers[h.Sum32()%uint32(len(m.workers))]
+}
+
+// WorkerStats holds cumulative delivery counters of a single worker.
+type WorkerStats struct {
+	Worker   int
+	Total    uint64
+	Succeed  uint64
+	Failed   uint64
+	Grouped  uint64
+	Deferred uint64
+}
+
+func (m *workerManager) stats() []WorkerStats {
+	res := make([]WorkerStats, 0, len(m.workers))
+	for _, w := range m.workers {
+		res = append(res, WorkerStats{
+			Worker:   w.id,
+			Total:    w.total.total.Load(),
+			Succeed:  w.total.succeed.Load(),
+			Failed:   w.total.failed.Load(),
+			Grouped:  w.total.grouped.Load(),
+			Deferred: w.total.deferred.Load(),
+		})
+	}
+	return res
+}
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "gosched"
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	registered   *prometheus.CounterVec
+	delivered    *prometheus.CounterVec
+	failed       *prometheus.CounterVec
+	grouped      *prometheus.CounterVec
+	deadLettered *prometheus.CounterVec
+	deferred     *prometheus.CounterVec
+
+	deliveryLatency *prometheus.HistogramVec
+	scheduleLag     *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+
+	pending   prometheus.Gauge
+	batchSize *prometheus.GaugeVec
+
+	compacted prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		registered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_registered_total",
+			Help:      "Number of registered tasks.",
+		}, []string{"method"}),
+		delivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_delivered_total",
+			Help:      "Number of successfully delivered tasks.",
+		}, []string{"method"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_failed_total",
+			Help:      "Number of failed delivery attempts.",
+		}, []string{"method"}),
+		grouped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_grouped_total",
+			Help:      "Number of tasks excluded by grouping.",
+		}, []string{"method"}),
+		deadLettered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_dead_lettered_total",
+			Help:      "Number of tasks that won't be delivered, failed permanently or out of retries.",
+		}, []string{"method"}),
+		deferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_deferred_total",
+			Help:      "Number of tasks left for the next scan by limits or the circuit breaker.",
+		}, []string{"method"}),
+		deliveryLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "delivery_duration_seconds",
+			Help:      "Time spent in the handler delivering a task.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		scheduleLag: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "schedule_lag_seconds",
+			Help:      "Time between the scheduled and the actual delivery of a task.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		}, []string{"method"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Time spent in database operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"op"}),
+		pending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "tasks_pending",
+			Help:      "Number of due tasks found by the last scan.",
+		}),
+		batchSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "batch_size",
+			Help:      "Number of tasks in the last committed batch.",
+		}, []string{"worker"}),
+		compacted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "grouping_keys_compacted_total",
+			Help:      "Number of expired grouping keys deleted from the dedup store.",
+		}),
+	}
+	m.registry.MustRegister(
+		m.registered,
+		m.delivered,
+		m.failed,
+		m.grouped,
+		m.deadLettered,
+		m.deferred,
+		m.deliveryLatency,
+		m.scheduleLag,
+		m.queryDuration,
+		m.pending,
+		m.batchSize,
+		m.compacted,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *metrics) registerBreaker(state func() BreakerState) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "sink_breaker_state",
+		Help:      "State of the sink circuit breaker: 0 closed, 1 open, 2 half-open.",
+	}, func() float64 {
+		return float64(state())
+	}))
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+func (m *metrics) observeQuery(op string, start time.Time) {
+	m.queryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) observeDelivery(t *Task, start time.Time, err error) {
+	m.deliveryLatency.WithLabelValues(t.Method).Observe(time.Since(start).Seconds())
+	if err == nil {
+		m.delivered.WithLabelValues(t.Method).Inc()
+		m.scheduleLag.WithLabelValues(t.Method).Observe(start.Sub(t.At).Seconds())
+		return
+	}
+	m.failed.WithLabelValues(t.Method).Inc()
+}
@@ -38,7 +38,17 @@ func (drpcEncoding_File_scheduler_proto) JSONUnmarshal(buf []byte, msg drpc.Mess
 type DRPCSchedulerServerClient interface {
 	DRPCConn() drpc.Conn
 
-	Register(ctx context.Context, in *Task) (*Empty, error)
+	Register(ctx context.Context, in *Task) (*RegisterResponse, error)
+	RegisterBatch(ctx context.Context, in *TaskBatch) (*RegisterBatchResponse, error)
+	Health(ctx context.Context, in *HealthRequest) (*HealthResponse, error)
+	GetTaskHistory(ctx context.Context, in *TaskHistoryRequest) (*TaskHistory, error)
+	Watch(ctx context.Context, in *WatchRequest) (DRPCSchedulerServer_WatchClient, error)
+	GetTask(ctx context.Context, in *TaskRequest) (*TaskInfo, error)
+	ListTasks(ctx context.Context, in *ListTasksRequest) (*TaskList, error)
+	CancelTask(ctx context.Context, in *TaskRequest) (*TaskInfo, error)
+	RetryTask(ctx context.Context, in *TaskRequest) (*TaskInfo, error)
+	Stats(ctx context.Context, in *StatsRequest) (*StatsResponse, error)
+	ReloadConfig(ctx context.Context, in *ReloadConfigRequest) (*ReloadConfigResponse, error)
 }
 
 type drpcSchedulerServerClient struct {
@@ -51,8 +61,8 @@ func NewDRPCSchedulerServerClient(cc drpc.Conn) DRPCSchedulerServerClient {
 
 func (c *drpcSchedulerServerClient) DRPCConn() drpc.Conn { return c.cc }
 
-func (c *drpcSchedulerServerClient) Register(ctx context.Context, in *Task) (*Empty, error) {
-	out := new(Empty)
+func (c *drpcSchedulerServerClient) Register(ctx context.Context, in *Task) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
 	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/Register", drpcEncoding_File_scheduler_proto{}, in, out)
 	if err != nil {
 		return nil, err
@@ -60,19 +70,190 @@ func (c *drpcSchedulerServerClient) Register(ctx context.Context, in *Task) (*Em
 	return out, nil
 }
 
+func (c *drpcSchedulerServerClient) RegisterBatch(ctx context.Context, in *TaskBatch) (*RegisterBatchResponse, error) {
+	out := new(RegisterBatchResponse)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/RegisterBatch", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *drpcSchedulerServerClient) Health(ctx context.Context, in *HealthRequest) (*HealthResponse, error) {
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/Health", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *drpcSchedulerServerClient) GetTaskHistory(ctx context.Context, in *TaskHistoryRequest) (*TaskHistory, error) {
+	out := new(TaskHistory)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/GetTaskHistory", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *drpcSchedulerServerClient) Watch(ctx context.Context, in *WatchRequest) (DRPCSchedulerServer_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, "/scheduler.SchedulerServer/Watch", drpcEncoding_File_scheduler_proto{})
+	if err != nil {
+		return nil, err
+	}
+	x := &drpcSchedulerServer_WatchClient{stream}
+	if err := x.MsgSend(in, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return nil, err
+	}
+	if err := x.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DRPCSchedulerServer_WatchClient interface {
+	drpc.Stream
+	Recv() (*Event, error)
+}
+
+type drpcSchedulerServer_WatchClient struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_WatchClient) GetStream() drpc.Stream {
+	return x.Stream
+}
+
+func (x *drpcSchedulerServer_WatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.MsgRecv(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (x *drpcSchedulerServer_WatchClient) RecvMsg(m *Event) error {
+	return x.MsgRecv(m, drpcEncoding_File_scheduler_proto{})
+}
+
+func (c *drpcSchedulerServerClient) GetTask(ctx context.Context, in *TaskRequest) (*TaskInfo, error) {
+	out := new(TaskInfo)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/GetTask", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *drpcSchedulerServerClient) ListTasks(ctx context.Context, in *ListTasksRequest) (*TaskList, error) {
+	out := new(TaskList)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/ListTasks", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *drpcSchedulerServerClient) CancelTask(ctx context.Context, in *TaskRequest) (*TaskInfo, error) {
+	out := new(TaskInfo)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/CancelTask", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *drpcSchedulerServerClient) RetryTask(ctx context.Context, in *TaskRequest) (*TaskInfo, error) {
+	out := new(TaskInfo)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/RetryTask", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *drpcSchedulerServerClient) Stats(ctx context.Context, in *StatsRequest) (*StatsResponse, error) {
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/Stats", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *drpcSchedulerServerClient) ReloadConfig(ctx context.Context, in *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	out := new(ReloadConfigResponse)
+	err := c.cc.Invoke(ctx, "/scheduler.SchedulerServer/ReloadConfig", drpcEncoding_File_scheduler_proto{}, in, out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 type DRPCSchedulerServerServer interface {
-	Register(context.Context, *Task) (*Empty, error)
+	Register(context.Context, *Task) (*RegisterResponse, error)
+	RegisterBatch(context.Context, *TaskBatch) (*RegisterBatchResponse, error)
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	GetTaskHistory(context.Context, *TaskHistoryRequest) (*TaskHistory, error)
+	Watch(*WatchRequest, DRPCSchedulerServer_WatchStream) error
+	GetTask(context.Context, *TaskRequest) (*TaskInfo, error)
+	ListTasks(context.Context, *ListTasksRequest) (*TaskList, error)
+	CancelTask(context.Context, *TaskRequest) (*TaskInfo, error)
+	RetryTask(context.Context, *TaskRequest) (*TaskInfo, error)
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error)
 }
 
 type DRPCSchedulerServerUnimplementedServer struct{}
 
-func (s *DRPCSchedulerServerUnimplementedServer) Register(context.Context, *Task) (*Empty, error) {
+func (s *DRPCSchedulerServerUnimplementedServer) Register(context.Context, *Task) (*RegisterResponse, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) RegisterBatch(context.Context, *TaskBatch) (*RegisterBatchResponse, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) Health(context.Context, *HealthRequest) (*HealthResponse, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) GetTaskHistory(context.Context, *TaskHistoryRequest) (*TaskHistory, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) Watch(*WatchRequest, DRPCSchedulerServer_WatchStream) error {
+	return drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) GetTask(context.Context, *TaskRequest) (*TaskInfo, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) ListTasks(context.Context, *ListTasksRequest) (*TaskList, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) CancelTask(context.Context, *TaskRequest) (*TaskInfo, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) RetryTask(context.Context, *TaskRequest) (*TaskInfo, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
+}
+
+func (s *DRPCSchedulerServerUnimplementedServer) ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error) {
 	return nil, drpcerr.WithCode(errors.New("Unimplemented"), drpcerr.Unimplemented)
 }
 
 type DRPCSchedulerServerDescription struct{}
 
-func (DRPCSchedulerServerDescription) NumMethods() int { return 1 }
+func (DRPCSchedulerServerDescription) NumMethods() int { return 11 }
 
 func (DRPCSchedulerServerDescription) Method(n int) (string, drpc.Encoding, drpc.Receiver, interface{}, bool) {
 	switch n {
@@ -85,6 +266,96 @@ func (DRPCSchedulerServerDescription) Method(n int) (string, drpc.Encoding, drpc
 						in1.(*Task),
 					)
 			}, DRPCSchedulerServerServer.Register, true
+	case 1:
+		return "/scheduler.SchedulerServer/RegisterBatch", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					RegisterBatch(
+						ctx,
+						in1.(*TaskBatch),
+					)
+			}, DRPCSchedulerServerServer.RegisterBatch, true
+	case 2:
+		return "/scheduler.SchedulerServer/Health", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					Health(
+						ctx,
+						in1.(*HealthRequest),
+					)
+			}, DRPCSchedulerServerServer.Health, true
+	case 3:
+		return "/scheduler.SchedulerServer/GetTaskHistory", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					GetTaskHistory(
+						ctx,
+						in1.(*TaskHistoryRequest),
+					)
+			}, DRPCSchedulerServerServer.GetTaskHistory, true
+	case 4:
+		return "/scheduler.SchedulerServer/Watch", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return nil, srv.(DRPCSchedulerServerServer).
+					Watch(
+						in1.(*WatchRequest),
+						&drpcSchedulerServer_WatchStream{in2.(drpc.Stream)},
+					)
+			}, DRPCSchedulerServerServer.Watch, true
+	case 5:
+		return "/scheduler.SchedulerServer/GetTask", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					GetTask(
+						ctx,
+						in1.(*TaskRequest),
+					)
+			}, DRPCSchedulerServerServer.GetTask, true
+	case 6:
+		return "/scheduler.SchedulerServer/ListTasks", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					ListTasks(
+						ctx,
+						in1.(*ListTasksRequest),
+					)
+			}, DRPCSchedulerServerServer.ListTasks, true
+	case 7:
+		return "/scheduler.SchedulerServer/CancelTask", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					CancelTask(
+						ctx,
+						in1.(*TaskRequest),
+					)
+			}, DRPCSchedulerServerServer.CancelTask, true
+	case 8:
+		return "/scheduler.SchedulerServer/RetryTask", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					RetryTask(
+						ctx,
+						in1.(*TaskRequest),
+					)
+			}, DRPCSchedulerServerServer.RetryTask, true
+	case 9:
+		return "/scheduler.SchedulerServer/Stats", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					Stats(
+						ctx,
+						in1.(*StatsRequest),
+					)
+			}, DRPCSchedulerServerServer.Stats, true
+	case 10:
+		return "/scheduler.SchedulerServer/ReloadConfig", drpcEncoding_File_scheduler_proto{},
+			func(srv interface{}, ctx context.Context, in1, in2 interface{}) (drpc.Message, error) {
+				return srv.(DRPCSchedulerServerServer).
+					ReloadConfig(
+						ctx,
+						in1.(*ReloadConfigRequest),
+					)
+			}, DRPCSchedulerServerServer.ReloadConfig, true
 	default:
 		return "", nil, nil, nil, false
 	}
@@ -96,14 +367,171 @@ func DRPCRegisterSchedulerServer(mux drpc.Mux, impl DRPCSchedulerServerServer) e
 
 type DRPCSchedulerServer_RegisterStream interface {
 	drpc.Stream
-	SendAndClose(*Empty) error
+	SendAndClose(*RegisterResponse) error
 }
 
 type drpcSchedulerServer_RegisterStream struct {
 	drpc.Stream
 }
 
-func (x *drpcSchedulerServer_RegisterStream) SendAndClose(m *Empty) error {
+func (x *drpcSchedulerServer_RegisterStream) SendAndClose(m *RegisterResponse) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_RegisterBatchStream interface {
+	drpc.Stream
+	SendAndClose(*RegisterBatchResponse) error
+}
+
+type drpcSchedulerServer_RegisterBatchStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_RegisterBatchStream) SendAndClose(m *RegisterBatchResponse) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_HealthStream interface {
+	drpc.Stream
+	SendAndClose(*HealthResponse) error
+}
+
+type drpcSchedulerServer_HealthStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_HealthStream) SendAndClose(m *HealthResponse) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_GetTaskHistoryStream interface {
+	drpc.Stream
+	SendAndClose(*TaskHistory) error
+}
+
+type drpcSchedulerServer_GetTaskHistoryStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_GetTaskHistoryStream) SendAndClose(m *TaskHistory) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_WatchStream interface {
+	drpc.Stream
+	Send(*Event) error
+}
+
+type drpcSchedulerServer_WatchStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_WatchStream) Send(m *Event) error {
+	return x.MsgSend(m, drpcEncoding_File_scheduler_proto{})
+}
+
+type DRPCSchedulerServer_GetTaskStream interface {
+	drpc.Stream
+	SendAndClose(*TaskInfo) error
+}
+
+type drpcSchedulerServer_GetTaskStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_GetTaskStream) SendAndClose(m *TaskInfo) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_ListTasksStream interface {
+	drpc.Stream
+	SendAndClose(*TaskList) error
+}
+
+type drpcSchedulerServer_ListTasksStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_ListTasksStream) SendAndClose(m *TaskList) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_CancelTaskStream interface {
+	drpc.Stream
+	SendAndClose(*TaskInfo) error
+}
+
+type drpcSchedulerServer_CancelTaskStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_CancelTaskStream) SendAndClose(m *TaskInfo) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_RetryTaskStream interface {
+	drpc.Stream
+	SendAndClose(*TaskInfo) error
+}
+
+type drpcSchedulerServer_RetryTaskStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_RetryTaskStream) SendAndClose(m *TaskInfo) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_StatsStream interface {
+	drpc.Stream
+	SendAndClose(*StatsResponse) error
+}
+
+type drpcSchedulerServer_StatsStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_StatsStream) SendAndClose(m *StatsResponse) error {
+	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
+		return err
+	}
+	return x.CloseSend()
+}
+
+type DRPCSchedulerServer_ReloadConfigStream interface {
+	drpc.Stream
+	SendAndClose(*ReloadConfigResponse) error
+}
+
+type drpcSchedulerServer_ReloadConfigStream struct {
+	drpc.Stream
+}
+
+func (x *drpcSchedulerServer_ReloadConfigStream) SendAndClose(m *ReloadConfigResponse) error {
 	if err := x.MsgSend(m, drpcEncoding_File_scheduler_proto{}); err != nil {
 		return err
 	}
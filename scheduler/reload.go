@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+)
+
+// Settings are the parts of the configuration that can be changed while the
+// scheduler is running.
+type Settings struct {
+	GroupingStrategy map[string]GroupingStrategy
+	Handler          Handler
+	Limits           map[string]Limit
+	SinkLimit        Limit
+	// Breaker wraps the handler in a CircuitBreaker when set.
+	Breaker *BreakerConfig
+}
+
+// Validate returns all problems of the settings joined together.
+func (st *Settings) Validate() error {
+	var errs []error
+	if st.Handler == nil {
+		errs = append(errs, errors.New("empty handler"))
+	}
+	for method, g := range st.GroupingStrategy {
+		if g.Method != method {
+			errs = append(errs, fmt.Errorf("grouping strategy of %q has method %q", method, g.Method))
+		}
+		if err := g.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("grouping strategy %q: %w", method, err))
+		}
+	}
+	for method, l := range st.Limits {
+		if l.MaxInFlight < 0 || l.Rate < 0 || l.Burst < 0 {
+			errs = append(errs, fmt.Errorf("negative limit for method %q", method))
+		}
+	}
+	if l := st.SinkLimit; l.MaxInFlight < 0 || l.Rate < 0 || l.Burst < 0 {
+		errs = append(errs, errors.New("negative sink limit"))
+	}
+	return errors.Join(errs...)
+}
+
+// settings are Settings prepared for workers. A batch keeps the settings it
+// was started with until it is committed, so tokens and in-flight slots are
+// always returned to the limiters they were taken from.
+type settings struct {
+	strategy    map[string]GroupingStrategy
+	handler     Handler
+	breaker     *BreakerConfig
+	limiters    map[string]*limiter
+	sinkLimiter *limiter
+}
+
+// newSettings prepares settings for workers. The circuit breaker and limiters
+// of the running settings are kept when their configuration didn't change, so
+// a reload doesn't close an open circuit or double the in-flight limits while
+// older batches finish.
+func (s *Scheduler) newSettings(st Settings) *settings {
+	var (
+		prev         = s.settings.Load()
+		prevLimiters map[string]*limiter
+		prevSink     *limiter
+	)
+	if prev != nil {
+		prevLimiters, prevSink = prev.limiters, prev.sinkLimiter
+	}
+
+	h := st.Handler
+	if st.Breaker != nil && h != nil {
+		if cb := prev.breakerFor(st); cb != nil {
+			cb.setHandler(h)
+			h = cb
+		} else {
+			h = NewCircuitBreaker(h, *st.Breaker, s.logger)
+		}
+	}
+	return &settings{
+		strategy:    st.GroupingStrategy,
+		handler:     h,
+		breaker:     st.Breaker,
+		limiters:    newLimiters(st.Limits, prevLimiters),
+		sinkLimiter: reuseLimiter(prevSink, st.SinkLimit),
+	}
+}
+
+// breakerFor returns the running circuit breaker when it has the
+// configuration of st and wraps a handler of the same sink.
+func (st *settings) breakerFor(next Settings) *CircuitBreaker {
+	if st == nil || st.breaker == nil || *st.breaker != *next.Breaker {
+		return nil
+	}
+	cb, ok := st.handler.(*CircuitBreaker)
+	if !ok || !sameSink(cb.handler(), next.Handler) {
+		return nil
+	}
+	return cb
+}
+
+// sameSink reports whether both handlers deliver to the same sink. Named
+// handlers are compared by their names, others have to be equal.
+func sameSink(a, b Handler) bool {
+	na, aok := a.(namedHandler)
+	nb, bok := b.(namedHandler)
+	if aok && bok {
+		return na.Name() == nb.Name()
+	}
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() && a == b
+}
+
+// WithReloader sets the function returning new settings when the scheduler
+// receives SIGHUP or the ReloadConfig rpc.
+func WithReloader(f func() (Settings, error)) Option {
+	return func(s *Scheduler) {
+		s.opts.reloader = f
+	}
+}
+
+// Reload validates the settings and swaps them atomically. Batches already
+// started are delivered with the previous settings. The circuit breaker and
+// limiters are kept when their configuration and the sink didn't change,
+// otherwise a circuit breaker starts closed. Invalid settings are rejected
+// and the running ones are kept.
+func (s *Scheduler) Reload(st Settings) error {
+	if err := st.Validate(); err != nil {
+		s.logger.Error("rejected settings", slog.Any("err", err))
+		return err
+	}
+	s.settings.Store(s.newSettings(st))
+	s.logger.Info("reloaded settings",
+		slog.Any("strategy", st.GroupingStrategy),
+		slog.Any("limits", st.Limits),
+		slog.String("handler", handlerName(st.Handler)))
+	return nil
+}
+
+// ErrReloadNotConfigured is returned by ReloadConfig without WithReloader.
+var ErrReloadNotConfigured = errors.New("reload is not configured")
+
+// ReloadConfig reloads settings returned by the function set with WithReloader.
+func (s *Scheduler) ReloadConfig() error {
+	if s.opts.reloader == nil {
+		return ErrReloadNotConfigured
+	}
+	st, err := s.opts.reloader()
+	if err != nil {
+		s.logger.Error("couldn't load settings", slog.Any("err", err))
+		return err
+	}
+	return s.Reload(st)
+}
+
+// admits reports whether one more task can be delivered within d after n
+// tasks of its method and total tasks. It takes no tokens, they are taken by
+// wait right before the delivery.
+func (st *settings) admits(t *Task, n, total int, d time.Duration) bool {
+	return st.limiters[t.Method].admits(n, d) && st.sinkLimiter.admits(total, d)
+}
+
+// wait takes rate tokens for the task, so deliveries are spaced by the rate
+// from the moment they start, however long the batch waited to be committed.
+func (st *settings) wait(ctx context.Context, t *Task) error {
+	if err := st.limiters[t.Method].wait(ctx); err != nil {
+		return err
+	}
+	return st.sinkLimiter.wait(ctx)
+}
+
+func (st *settings) tryAcquire(t *Task) bool {
+	ml := st.limiters[t.Method]
+	if !ml.tryAcquire() {
+		return false
+	}
+	if !st.sinkLimiter.tryAcquire() {
+		ml.release()
+		return false
+	}
+	return true
+}
+
+func (st *settings) release(t *Task) {
+	st.sinkLimiter.release()
+	st.limiters[t.Method].release()
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// replace overwrites the pending task of the group with the task.
+func (s *Scheduler) replace(g GroupingStrategy, t *Task) (bool, error) {
+	t.GroupKey = g.group(t)
+	return s.replacePending(t, g.KeepEarliest)
+}
+
+// replacePending overwrites the pending task of the group of t with it, t
+// gets its id. Tasks that are due may be being delivered, so they are kept
+// and the task is registered next to them.
+func (s *Scheduler) replacePending(t *Task, keepEarliest bool) (bool, error) {
+	now := time.Now()
+
+	start := time.Now()
+	id, err := s.db.FindGroup(t.GroupKey, now)
+	s.metrics.observeQuery("find_group", start)
+	if errors.Is(err, ErrTaskNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if keepEarliest {
+		old, err := s.db.GetTask(id)
+		if err != nil && !errors.Is(err, ErrTaskNotFound) {
+			return false, err
+		}
+		if err == nil {
+			defer old.Dispose()
+			if old.At.Before(t.At) {
+				t.At = old.At
+			}
+		}
+	}
+
+	start = time.Now()
+	res, err := s.db.ReplaceTask(id, t, now)
+	s.metrics.observeQuery("replace_task", start)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// the task became due meanwhile
+		return false, nil
+	}
+	s.logger.Debug("replaced task",
+		slog.Int("id", id), slog.String("group", t.GroupKey))
+	s.metrics.grouped.WithLabelValues(t.Method).Inc()
+	t.Id = id
+	return true, nil
+}
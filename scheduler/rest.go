@@ -0,0 +1,356 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gosched/jsonschema"
+)
+
+const (
+	restTimeout      = 5 * time.Second
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// restTaskRequest is the body of POST /v1/tasks.
+type restTaskRequest struct {
+	Method      string            `json:"method" description:"Method of the sink the task is delivered to."`
+	Params      map[string]string `json:"params,omitempty" description:"Parameters sent to the sink."`
+	At          string            `json:"at" description:"Delivery time in RFC3339."`
+	CallbackUrl string            `json:"callback_url,omitempty" description:"Url notified about the result of the delivery."`
+	Labels      map[string]string `json:"labels,omitempty" description:"Labels not sent to the sink."`
+	// IdempotencyKey can also be sent in the Idempotency-Key header.
+	IdempotencyKey string `json:"idempotency_key,omitempty" description:"Registering a task with the same key again returns the first task."`
+}
+
+type restTask struct {
+	Id          int               `json:"id"`
+	Method      string            `json:"method"`
+	Params      map[string]string `json:"params"`
+	At          time.Time         `json:"at"`
+	Status      string            `json:"status" description:"One of pending, completed, failed, cancelled."`
+	Retries     int               `json:"retries"`
+	CallbackUrl string            `json:"callback_url,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+type restTaskList struct {
+	Tasks []restTask `json:"tasks"`
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+func toRestTask(t *Task) restTask {
+	return restTask{
+		Id:          t.Id,
+		Method:      t.Method,
+		Params:      t.Parameters,
+		At:          t.At,
+		Status:      t.Status.String(),
+		Retries:     t.Retries,
+		CallbackUrl: t.Callback,
+		Labels:      t.Labels,
+	}
+}
+
+// restHandler serves the resource oriented json api under /v1.
+type restHandler struct {
+	s *Scheduler
+}
+
+func (h *restHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /v1/tasks", h.createTask)
+	mux.HandleFunc("GET /v1/tasks", h.listTasks)
+	mux.HandleFunc("GET /v1/tasks/{id}", h.getTask)
+	mux.HandleFunc("DELETE /v1/tasks/{id}", h.cancelTask)
+	mux.HandleFunc("GET /v1/openapi.json", h.openAPI)
+}
+
+func (h *restHandler) createTask(w http.ResponseWriter, r *http.Request) {
+	var req restTaskRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	t, err := parseTask(req.Method, req.Params, req.At, req.CallbackUrl, req.Labels)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	t.IdempotencyKey = req.IdempotencyKey
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		t.IdempotencyKey = key
+	}
+
+	ctx, span := h.s.tracer.Start(r.Context(), "REST.createTask")
+	defer span.End()
+	t.Trace = encodeTrace(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, restTimeout)
+	defer cancel()
+	id, err := h.s.Schedule(ctx, t)
+	if err != nil {
+		recordError(span, err)
+		writeScheduleError(w, err)
+		return
+	}
+
+	// a replayed, merged or replaced task is answered with the stored one
+	stored, err := h.s.db.GetTask(id)
+	if err != nil {
+		recordError(span, err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer stored.Dispose()
+
+	status := http.StatusOK
+	if t.created {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Location", "/v1/tasks/"+strconv.Itoa(id))
+	writeJSON(w, status, toRestTask(stored))
+}
+
+func (h *restHandler) getTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathId(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := h.s.db.GetTask(id)
+	if err != nil {
+		writeDbError(w, err)
+		return
+	}
+	defer t.Dispose()
+	writeJSON(w, http.StatusOK, toRestTask(t))
+}
+
+func (h *restHandler) cancelTask(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathId(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.s.Cancel(id); err != nil {
+		writeDbError(w, err)
+		return
+	}
+
+	t, err := h.s.db.GetTask(id)
+	if err != nil {
+		writeDbError(w, err)
+		return
+	}
+	defer t.Dispose()
+	writeJSON(w, http.StatusOK, toRestTask(t))
+}
+
+func (h *restHandler) listTasks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := TaskFilter{
+		Method: q.Get("method"),
+		Limit:  defaultListLimit,
+	}
+	if v := q.Get("status"); v != "" {
+		status, err := ParseTaskStatus(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		f.Status = &status
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(maxListLimit)))
+			return
+		}
+		f.Limit = limit
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid offset"))
+			return
+		}
+		f.Offset = offset
+	}
+
+	tasks, err := h.s.db.ListTasks(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	res := restTaskList{Tasks: make([]restTask, 0, len(tasks))}
+	for _, t := range tasks {
+		res.Tasks = append(res.Tasks, toRestTask(t))
+		t.Dispose()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *restHandler) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+func pathId(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("invalid task id"))
+		return 0, false
+	}
+	return id, true
+}
+
+func writeDbError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrTaskNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrTaskNotPending):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// writeScheduleError tells a rejected task from a scheduler too busy to
+// register it in time.
+func writeScheduleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidTask):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, err)
+	case errors.Is(err, context.Canceled):
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, restError{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// openAPIDocument describes the rest api, schemas are generated from the
+// request and response types.
+func openAPIDocument() map[string]any {
+	var (
+		schema = func(v any) jsonschema.Schema {
+			return jsonschema.For(reflect.TypeOf(v), "json")
+		}
+		ref = func(name string) map[string]any {
+			return map[string]any{"$ref": "#/components/schemas/" + name}
+		}
+		content = func(name string) map[string]any {
+			return map[string]any{"application/json": map[string]any{"schema": ref(name)}}
+		}
+		response = func(desc, name string) map[string]any {
+			return map[string]any{"description": desc, "content": content(name)}
+		}
+		errResponse = func(desc string) map[string]any {
+			return response(desc, "Error")
+		}
+		idParam = map[string]any{
+			"name": "id", "in": "path", "required": true,
+			"schema": map[string]any{"type": "integer"},
+		}
+		queryParam = func(name, desc string, s map[string]any) map[string]any {
+			return map[string]any{"name": name, "in": "query", "description": desc, "schema": s}
+		}
+	)
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "gosched",
+			"version": "v1",
+		},
+		"paths": map[string]any{
+			"/v1/tasks": map[string]any{
+				"post": map[string]any{
+					"summary":     "Register a task",
+					"operationId": "createTask",
+					"requestBody": map[string]any{"required": true, "content": content("TaskRequest")},
+					"responses": map[string]any{
+						"200": response("Task stored before with the same idempotency key, or the task it was grouped into", "Task"),
+						"201": response("Registered task", "Task"),
+						"400": errResponse("Invalid task"),
+						"500": errResponse("Task couldn't be stored"),
+						"503": errResponse("Registration was cancelled"),
+						"504": errResponse("Task wasn't registered in time"),
+					},
+				},
+				"get": map[string]any{
+					"summary":     "List tasks",
+					"operationId": "listTasks",
+					"parameters": []any{
+						queryParam("method", "Method of tasks", map[string]any{"type": "string"}),
+						queryParam("status", "Status of tasks", map[string]any{
+							"type": "string",
+							"enum": []string{"pending", "completed", "failed", "cancelled"},
+						}),
+						queryParam("limit", "Maximum number of tasks", map[string]any{
+							"type": "integer", "minimum": 1, "maximum": maxListLimit, "default": defaultListLimit,
+						}),
+						queryParam("offset", "Number of tasks to skip", map[string]any{"type": "integer", "minimum": 0}),
+					},
+					"responses": map[string]any{
+						"200": response("Tasks ordered by id", "TaskList"),
+						"400": errResponse("Invalid filter"),
+					},
+				},
+			},
+			"/v1/tasks/{id}": map[string]any{
+				"parameters": []any{idParam},
+				"get": map[string]any{
+					"summary":     "Get a task",
+					"operationId": "getTask",
+					"responses": map[string]any{
+						"200": response("Task", "Task"),
+						"400": errResponse("Invalid task id"),
+						"404": errResponse("Task not found"),
+					},
+				},
+				"delete": map[string]any{
+					"summary":     "Cancel a pending task",
+					"operationId": "cancelTask",
+					"responses": map[string]any{
+						"200": response("Cancelled task", "Task"),
+						"400": errResponse("Invalid task id"),
+						"404": errResponse("Task not found"),
+						"409": errResponse("Task is not pending"),
+					},
+				},
+			},
+		},
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"TaskRequest": schema(restTaskRequest{}),
+				"Task":        schema(restTask{}),
+				"TaskList":    schema(restTaskList{}),
+				"Error":       schema(restError{}),
+			},
+		},
+	}
+}
@@ -3,43 +3,59 @@ package scheduler
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/VictoriaMetrics/fastcache"
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	dbName = "scheduler.db"
 )
 
-type GroupingStrategy struct {
-	Method     string
-	TimeFormat string
-	Param      []string
-}
-
 type Scheduler struct {
 	db Database
 
 	level     *slog.LevelVar
 	logger    *slog.Logger
 	exitChan  chan struct{}
-	w         *worker // make it array, or create workers manager
+	workers   *workerManager
+	callbacks *callbackSender
+	compactor *compactor
 	taskQueue chan *Task
 
-	cache *fastcache.Cache // make iface
+	dedup DedupStore
 
-	handler Handler
 	logfile *os.File
 
+	// swapped by Reload
+	settings atomic.Pointer[settings]
+
+	metrics *metrics
+	tracer  trace.Tracer
+	health  *health
+	events  *eventBus
+
 	opts struct {
+		handler          Handler
 		batchSize        int
 		port             string
 		groupingStrategy map[string]GroupingStrategy
 		ticker           *time.Duration
+		workers          int
+		partition        Partition
+		limits           map[string]Limit
+		sinkLimit        Limit
+		breaker          *BreakerConfig
+		reloader         func() (Settings, error)
 	}
 }
 
@@ -47,7 +63,7 @@ type Option func(*Scheduler)
 
 func WithHandler(h Handler) Option {
 	return func(s *Scheduler) {
-		s.handler = h
+		s.opts.handler = h
 	}
 }
 
@@ -87,6 +103,42 @@ func WithTicker(ticker *time.Duration) Option {
 	}
 }
 
+// WithWorkers sets the number of workers delivering tasks concurrently.
+func WithWorkers(n int) Option {
+	return func(s *Scheduler) {
+		s.opts.workers = n
+	}
+}
+
+// WithPartition sets how tasks are assigned to workers.
+func WithPartition(p Partition) Option {
+	return func(s *Scheduler) {
+		s.opts.partition = p
+	}
+}
+
+// WithLimits sets delivery limits per method. Tasks over the limit are left
+// for the next scan instead of failing.
+func WithLimits(m map[string]Limit) Option {
+	return func(s *Scheduler) {
+		s.opts.limits = m
+	}
+}
+
+// WithSinkLimit sets delivery limits shared by every task sent to the handler.
+func WithSinkLimit(l Limit) Option {
+	return func(s *Scheduler) {
+		s.opts.sinkLimit = l
+	}
+}
+
+// WithCircuitBreaker wraps the handler in a CircuitBreaker.
+func WithCircuitBreaker(cfg BreakerConfig) Option {
+	return func(s *Scheduler) {
+		s.opts.breaker = &cfg
+	}
+}
+
 func NewScheduler(logPath string, opts ...Option) (*Scheduler, error) {
 	levelVar := new(slog.LevelVar)
 	levelVar.Set(slog.LevelDebug)
@@ -101,11 +153,13 @@ func NewScheduler(logPath string, opts ...Option) (*Scheduler, error) {
 		out = os.Stdout
 	}
 	s := &Scheduler{
-		level: new(slog.LevelVar),
+		level: levelVar,
 		logger: slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{
 			Level: levelVar,
 		})),
 		logfile: out,
+		tracer:  defaultTracer(),
+		events:  newEventBus(),
 	}
 	for _, opt := range opts {
 		opt(s)
@@ -113,21 +167,51 @@ func NewScheduler(logPath string, opts ...Option) (*Scheduler, error) {
 	if s.db == nil {
 		return nil, errors.New("empty database")
 	}
-	s.cache = fastcache.New(4096) // 32MB by default
+	s.metrics = newMetrics()
+	s.metrics.registerBreaker(s.BreakerState)
+	if s.dedup == nil {
+		if d, ok := s.db.(DedupStore); ok {
+			s.dedup = d
+		} else {
+			s.dedup = NewMemoryDedupStore()
+		}
+	}
+	st := Settings{
+		GroupingStrategy: s.opts.groupingStrategy,
+		Handler:          s.opts.handler,
+		Limits:           s.opts.limits,
+		SinkLimit:        s.opts.sinkLimit,
+		Breaker:          s.opts.breaker,
+	}
+	if err := st.Validate(); err != nil {
+		return nil, err
+	}
+	s.settings.Store(s.newSettings(st))
 	s.taskQueue = make(chan *Task)
 	s.logger.Info("starting scheduler",
 		slog.Any("strategy", s.opts.groupingStrategy))
-	s.w, err = s.newWorker()
+	s.workers, err = s.newWorkerManager()
 	if err != nil {
 		return nil, err
 	}
+	s.callbacks = s.newCallbackSender()
+	s.compactor = s.newCompactor()
+	s.health = &health{
+		db:      s.db,
+		m:       s.workers,
+		breaker: s.BreakerState,
+	}
 	return s, nil
 }
 
 func (s *Scheduler) Start() {
-	go s.w.start()
+	go s.workers.start()
+	go s.callbacks.start()
+	if s.compactor != nil {
+		go s.compactor.start()
+	}
 	go func() {
-		err := startServer(s.taskQueue, s.opts.port)
+		err := s.startServer()
 		if err != nil {
 			s.logger.Error("error initializing server", slog.Any("error", err))
 			return
@@ -135,9 +219,19 @@ func (s *Scheduler) Start() {
 	}()
 	//sigs := make(chan os.Signal, 1)
 	//signal.Notify(sigs, syscall.SIGINT)
+	var hup chan os.Signal
+	if s.opts.reloader != nil {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
 	s.logger.Debug("server started")
 	for {
 		select {
+		case <-hup:
+			s.logger.Info("received SIGHUP, reloading settings")
+			// errors are logged by ReloadConfig, the running settings are kept
+			_ = s.ReloadConfig()
 		case t := <-s.taskQueue:
 			err := s.register(t)
 			if err != nil {
@@ -147,29 +241,90 @@ func (s *Scheduler) Start() {
 					slog.Any("parameters", t.Parameters),
 					slog.Time("at", t.At))
 			}
+			if t.registered != nil {
+				t.registered <- err
+			}
 		//case <-sigs:
 		//	s.logger.Info("received shutdown signal")
 		//	s.exitChan <- struct{}{}
 		case <-s.exitChan:
-			s.w.exitChan <- struct{}{}
+			s.workers.stop()
+			s.callbacks.stop()
+			if s.compactor != nil {
+				s.compactor.stop()
+			}
 			return
 		}
 	}
 }
 
 func (s *Scheduler) register(t *Task) error {
-	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(50*time.Millisecond))
+	ctx, span := s.tracer.Start(decodeTrace(context.Background(), t.Trace), "Scheduler.register",
+		trace.WithAttributes(attribute.String("method", t.Method)))
+	defer span.End()
+	// delivery links to this span
+	t.Trace = encodeTrace(ctx)
+
+	if t.IdempotencyKey != "" {
+		id, err := s.db.FindIdempotent(t.IdempotencyKey)
+		if err == nil {
+			// registered before, probably a retry of the producer
+			s.logger.Debug("task already registered",
+				slog.Int("id", id), slog.String("idempotencyKey", t.IdempotencyKey))
+			span.SetAttributes(attribute.Int("task", id), attribute.Bool("duplicate", true))
+			t.Id = id
+			return nil
+		}
+		if !errors.Is(err, ErrTaskNotFound) {
+			s.logger.Error("couldn't look up idempotency key", slog.Any("err", err))
+			recordError(span, err)
+			return err
+		}
+	}
+
+	if g, ok := s.settings.Load().strategy[t.Method]; ok && g.onRegister() {
+		var (
+			grouped bool
+			err     error
+		)
+		switch g.Mode {
+		case GroupingMerge:
+			grouped, err = s.merge(g, t)
+		case GroupingReplace:
+			grouped, err = s.replace(g, t)
+		case GroupingDebounce:
+			grouped, err = s.debounce(g, t)
+		}
+		if err != nil {
+			s.logger.Error("couldn't group task", slog.Any("err", err))
+			recordError(span, err)
+			return err
+		}
+		if grouped {
+			span.SetAttributes(attribute.Int("task", t.Id), attribute.Bool("grouped", true))
+			// the task isn't stored, watchers of the task it was grouped
+			// into learn about it
+			s.events.publish(newEvent(EventGrouped, t))
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(50*time.Millisecond))
 	defer cancel()
 
+	defer s.metrics.observeQuery("insert_task", time.Now())
+
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		s.logger.Error("couldn't begin transaction", slog.Any("err", err))
+		recordError(span, err)
 		return err
 	}
 
 	res, err := t.insert(tx)
 	if err != nil {
 		s.logger.Error("couldn't insert new task", slog.Any("err", err))
+		recordError(span, err)
 		tx.Rollback()
 		return err
 	}
@@ -185,8 +340,128 @@ func (s *Scheduler) register(t *Task) error {
 	err = tx.Commit()
 	if err != nil {
 		s.logger.Error("error commiting transaction", slog.Any("err", err))
+		recordError(span, err)
 		return err
 	}
 
+	span.SetAttributes(attribute.Int64("task", lastid))
+	s.metrics.registered.WithLabelValues(t.Method).Inc()
+	t.Id = int(lastid)
+	t.created = true
+	s.events.publish(newEvent(EventRegistered, t))
 	return nil
 }
+
+// routes returns http endpoints served next to the drpc http bridge.
+func (s *Scheduler) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.handler())
+	mux.HandleFunc("/healthz", s.health.livenessHandler)
+	mux.HandleFunc("/readyz", s.health.readinessHandler)
+	(&restHandler{s: s}).register(mux)
+	return mux
+}
+
+// ErrInvalidTask is returned when registering a task the scheduler can't
+// accept, whatever the state of the database.
+var ErrInvalidTask = errors.New("invalid task")
+
+// ErrTaskNotPending is returned when cancelling a task that was already delivered, failed or cancelled.
+var ErrTaskNotPending = errors.New("task is not pending")
+
+// Schedule registers the task and waits until it is stored, returning its id.
+func (s *Scheduler) Schedule(ctx context.Context, t *Task) (int, error) {
+	if t.Method == "" {
+		return 0, fmt.Errorf("%w: empty method", ErrInvalidTask)
+	}
+	t.registered = make(chan error, 1)
+	select {
+	case s.taskQueue <- t:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	select {
+	case err := <-t.registered:
+		return t.Id, err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Cancel cancels a pending task. A task that is being delivered at the moment
+// may still be delivered.
+func (s *Scheduler) Cancel(id int) error {
+	res, err := s.db.CancelTask(id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// nothing was cancelled, find out why
+		t, err := s.db.GetTask(id)
+		if err != nil {
+			return err
+		}
+		t.Dispose()
+		return ErrTaskNotPending
+	}
+	s.publishCancelled(id)
+	return nil
+}
+
+// ErrTaskNotFailed is returned when retrying a task that is neither failed nor cancelled.
+var ErrTaskNotFailed = errors.New("task is not failed or cancelled")
+
+// Retry makes a failed or cancelled task pending again, it is delivered as
+// soon as possible with a fresh retry budget.
+func (s *Scheduler) Retry(id int) error {
+	res, err := s.db.RequeueTask(id, time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		t, err := s.db.GetTask(id)
+		if err != nil {
+			return err
+		}
+		t.Dispose()
+		return ErrTaskNotFailed
+	}
+	return nil
+}
+
+func (s *Scheduler) publishCancelled(id int) {
+	t, err := s.db.GetTask(id)
+	if err != nil {
+		s.logger.Error("error getting cancelled task", slog.Any("err", err))
+		return
+	}
+	s.events.publish(newEvent(EventCancelled, t))
+	t.Dispose()
+}
+
+// WorkerStats returns cumulative delivery counters of every worker.
+func (s *Scheduler) WorkerStats() []WorkerStats {
+	return s.workers.stats()
+}
+
+// Health returns status of the scheduler components.
+func (s *Scheduler) Health(ctx context.Context) []ComponentStatus {
+	return s.health.check(ctx)
+}
+
+// BreakerState returns the state of the sink circuit breaker, it is always
+// closed when the scheduler runs without one.
+func (s *Scheduler) BreakerState() BreakerState {
+	if cb, ok := s.settings.Load().handler.(*CircuitBreaker); ok {
+		return cb.State()
+	}
+	return BreakerClosed
+}
@@ -3,12 +3,16 @@ package scheduler
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/gosched/scheduler/pb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"storj.io/drpc/drpchttp"
 	"storj.io/drpc/drpcmigrate"
@@ -16,54 +20,318 @@ import (
 	"storj.io/drpc/drpcserver"
 )
 
-var emptyRes = &pb.Empty{}
-
-// todo: add web sockets
-// todo: add drpc
-
 type Server struct {
 	pb.DRPCSchedulerServerUnimplementedServer
 
-	taskQue chan *Task
+	sched  *Scheduler
+	db     Database
+	tracer trace.Tracer
+	health *health
+	events *eventBus
+}
+
+func (s *Server) Register(ctx context.Context, pbt *pb.Task) (*pb.RegisterResponse, error) {
+	ctx, span := s.tracer.Start(ctx, "SchedulerServer.Register")
+	defer span.End()
+
+	id, err := s.register(ctx, pbt)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("task", id))
+	return &pb.RegisterResponse{Id: int64(id)}, nil
+}
+
+// RegisterBatch registers tasks in order and stops at the first invalid one,
+// tasks registered before it are kept.
+func (s *Server) RegisterBatch(ctx context.Context, batch *pb.TaskBatch) (*pb.RegisterBatchResponse, error) {
+	ctx, span := s.tracer.Start(ctx, "SchedulerServer.RegisterBatch")
+	defer span.End()
+
+	if batch == nil {
+		batch = &pb.TaskBatch{}
+	}
+	res := &pb.RegisterBatchResponse{
+		Ids: make([]int64, 0, len(batch.Tasks)),
+	}
+	for i, pbt := range batch.Tasks {
+		id, err := s.register(ctx, pbt)
+		if err != nil {
+			recordError(span, err)
+			return nil, fmt.Errorf("task %d: %w", i, err)
+		}
+		res.Ids = append(res.Ids, int64(id))
+	}
+	span.SetAttributes(attribute.Int("tasks", len(res.Ids)))
+	return res, nil
 }
 
-func (s *Server) Register(ctx context.Context, pbt *pb.Task) (*pb.Empty, error) {
+func (s *Server) register(ctx context.Context, pbt *pb.Task) (int, error) {
 	if pbt == nil {
-		return emptyRes, errors.New("empty task")
+		return 0, errors.New("empty task")
 	}
 
-	var (
-		at  time.Time
-		err error
-	)
+	t, err := parseTask(pbt.Method, pbt.Params, pbt.At, pbt.CallbackUrl, pbt.Labels)
+	if err != nil {
+		return 0, err
+	}
+	t.IdempotencyKey = pbt.IdempotencyKey
+	t.Trace = encodeTrace(ctx)
+
+	return s.sched.Schedule(ctx, t)
+}
+
+// parseTask validates a task received by one of the apis.
+func parseTask(method string, params map[string]string, at, callback string, labels map[string]string) (*Task, error) {
+	if method == "" {
+		return nil, fmt.Errorf("%w: empty method", ErrInvalidTask)
+	}
+
+	tat, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTask, err)
+	}
+
+	if callback != "" {
+		if _, err := url.ParseRequestURI(callback); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidTask, err)
+		}
+	}
+
+	return &Task{
+		Method:     method,
+		Parameters: params,
+		At:         tat,
+		Callback:   callback,
+		Labels:     labels,
+	}, nil
+}
+
+func (s *Server) Health(ctx context.Context, _ *pb.HealthRequest) (*pb.HealthResponse, error) {
+	components := s.health.check(ctx)
+	res := &pb.HealthResponse{
+		Healthy:    healthy(components),
+		Components: make([]*pb.ComponentHealth, 0, len(components)),
+	}
+	for _, c := range components {
+		res.Components = append(res.Components, &pb.ComponentHealth{
+			Name:    c.Name,
+			Healthy: c.Healthy,
+			Message: c.Message,
+		})
+	}
+	return res, nil
+}
+
+func (s *Server) GetTaskHistory(ctx context.Context, req *pb.TaskHistoryRequest) (*pb.TaskHistory, error) {
+	if req == nil || req.Id <= 0 {
+		return nil, errors.New("invalid task id")
+	}
+
+	attempts, err := s.db.GetAttempts(int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.TaskHistory{
+		Attempts: make([]*pb.Attempt, 0, len(attempts)),
+	}
+	for _, a := range attempts {
+		res.Attempts = append(res.Attempts, &pb.Attempt{
+			TaskId:     int64(a.TaskId),
+			Number:     int32(a.Number),
+			StartedAt:  a.StartedAt.Format(time.RFC3339Nano),
+			FinishedAt: a.FinishedAt.Format(time.RFC3339Nano),
+			Handler:    a.Handler,
+			StatusCode: int32(a.StatusCode),
+			Response:   a.Response,
+			Error:      a.Error,
+		})
+	}
+	return res, nil
+}
+
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.DRPCSchedulerServer_WatchStream) error {
+	if req == nil {
+		req = &pb.WatchRequest{}
+	}
+	sub := s.events.subscribe(EventFilter{
+		Method: req.Method,
+		TaskId: int(req.TaskId),
+		Labels: req.Labels,
+	})
+	defer s.events.unsubscribe(sub)
+
+	ctx := stream.Context()
+	for {
+		select {
+		case e := <-sub.ch:
+			if err := stream.Send(toPbEvent(e)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *Server) GetTask(ctx context.Context, req *pb.TaskRequest) (*pb.TaskInfo, error) {
+	if req == nil || req.Id <= 0 {
+		return nil, errors.New("invalid task id")
+	}
+	return s.taskInfo(int(req.Id))
+}
+
+func (s *Server) ListTasks(ctx context.Context, req *pb.ListTasksRequest) (*pb.TaskList, error) {
+	if req == nil {
+		req = &pb.ListTasksRequest{}
+	}
+	f := TaskFilter{
+		Method: req.Method,
+		Limit:  int(req.Limit),
+		Offset: int(req.Offset),
+	}
+	if f.Limit <= 0 || f.Limit > maxListLimit {
+		f.Limit = defaultListLimit
+	}
+	if req.Status != "" {
+		status, err := ParseTaskStatus(req.Status)
+		if err != nil {
+			return nil, err
+		}
+		f.Status = &status
+	}
 
-	at, err = time.Parse(time.RFC3339, pbt.At)
+	tasks, err := s.db.ListTasks(f)
 	if err != nil {
-		return emptyRes, err
+		return nil, err
+	}
+	res := &pb.TaskList{
+		Tasks: make([]*pb.TaskInfo, 0, len(tasks)),
+	}
+	for _, t := range tasks {
+		res.Tasks = append(res.Tasks, toPbTaskInfo(t))
+		t.Dispose()
 	}
+	return res, nil
+}
 
-	t := &Task{
-		Method:     pbt.Method,
-		Parameters: pbt.Params,
-		At:         at,
+func (s *Server) CancelTask(ctx context.Context, req *pb.TaskRequest) (*pb.TaskInfo, error) {
+	if req == nil || req.Id <= 0 {
+		return nil, errors.New("invalid task id")
+	}
+	if err := s.sched.Cancel(int(req.Id)); err != nil {
+		return nil, err
 	}
+	return s.taskInfo(int(req.Id))
+}
 
-	s.taskQue <- t
-	return emptyRes, nil
+func (s *Server) RetryTask(ctx context.Context, req *pb.TaskRequest) (*pb.TaskInfo, error) {
+	if req == nil || req.Id <= 0 {
+		return nil, errors.New("invalid task id")
+	}
+	if err := s.sched.Retry(int(req.Id)); err != nil {
+		return nil, err
+	}
+	return s.taskInfo(int(req.Id))
 }
 
-func startServer(q chan *Task, port string) error {
-	s := &Server{
-		taskQue: q,
+func (s *Server) Stats(ctx context.Context, _ *pb.StatsRequest) (*pb.StatsResponse, error) {
+	counts, err := s.db.CountTasks()
+	if err != nil {
+		return nil, err
+	}
+
+	workers := s.sched.WorkerStats()
+	res := &pb.StatsResponse{
+		Workers: make([]*pb.WorkerStats, 0, len(workers)),
+		Tasks:   make(map[string]int64, len(counts)),
 	}
+	for _, w := range workers {
+		res.Workers = append(res.Workers, &pb.WorkerStats{
+			Worker:   int32(w.Worker),
+			Total:    w.Total,
+			Succeed:  w.Succeed,
+			Failed:   w.Failed,
+			Grouped:  w.Grouped,
+			Deferred: w.Deferred,
+		})
+	}
+	for status, n := range counts {
+		res.Tasks[status.String()] = int64(n)
+	}
+	return res, nil
+}
+
+// ReloadConfig reloads grouping strategies, the sink and limits, the running
+// ones are kept when the new configuration is invalid.
+func (s *Server) ReloadConfig(ctx context.Context, _ *pb.ReloadConfigRequest) (*pb.ReloadConfigResponse, error) {
+	if err := s.sched.ReloadConfig(); err != nil {
+		return nil, err
+	}
+	return &pb.ReloadConfigResponse{}, nil
+}
+
+func (s *Server) taskInfo(id int) (*pb.TaskInfo, error) {
+	t, err := s.db.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Dispose()
+	return toPbTaskInfo(t), nil
+}
+
+func toPbTaskInfo(t *Task) *pb.TaskInfo {
+	return &pb.TaskInfo{
+		Id:          int64(t.Id),
+		Method:      t.Method,
+		Params:      t.Parameters,
+		At:          t.At.Format(time.RFC3339),
+		Status:      t.Status.String(),
+		Retries:     int32(t.Retries),
+		CallbackUrl: t.Callback,
+		Labels:      t.Labels,
+	}
+}
+
+func toPbEvent(e Event) *pb.Event {
+	return &pb.Event{
+		Type:   string(e.Type),
+		TaskId: int64(e.TaskId),
+		Method: e.Method,
+		Labels: e.Labels,
+		At:     e.At.Format(time.RFC3339),
+		Time:   e.Time.Format(time.RFC3339Nano),
+		Error:  e.Error,
+	}
+}
+
+// startServer serves drpc and its http bridge on the configured port. Http
+// endpoints from routes are served next to the bridge.
+func (s *Scheduler) startServer() error {
+	var (
+		port   = s.opts.port
+		routes = s.routes()
+	)
+	srv := &Server{
+		sched:  s,
+		db:     s.db,
+		tracer: s.tracer,
+		health: s.health,
+		events: s.events,
+	}
+
+	routes.Handle("/ws", newWebsocketHandler(srv, s.logger))
 
 	m := drpcmux.New()
-	err := pb.DRPCRegisterSchedulerServer(m, s)
+	err := pb.DRPCRegisterSchedulerServer(m, srv)
 	if err != nil {
 		return err
 	}
 
-	if !strings.HasPrefix(port, ":") {
+	// a bare port listens on every interface
+	if !strings.Contains(port, ":") {
 		port = ":" + port
 	}
 
@@ -71,6 +339,8 @@ func startServer(q chan *Task, port string) error {
 	if err != nil {
 		return err
 	}
+	s.health.listening.Store(true)
+	defer s.health.listening.Store(false)
 	var (
 		group errgroup.Group
 
@@ -87,7 +357,8 @@ func startServer(q chan *Task, port string) error {
 
 	// http handling
 	group.Go(func() error {
-		s := http.Server{Handler: drpchttp.New(m)}
+		routes.Handle("/", drpchttp.New(m))
+		s := http.Server{Handler: routes}
 		return s.Serve(httpLis)
 	})
 
@@ -2,10 +2,46 @@ package scheduler
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"sync"
 	"time"
 )
 
+// TaskStatus is the state of a task stored in the database.
+type TaskStatus int
+
+const (
+	TaskPending TaskStatus = iota
+	TaskCompleted
+	TaskFailed
+	TaskCancelled
+)
+
+func (s TaskStatus) String() string {
+	switch s {
+	case TaskPending:
+		return "pending"
+	case TaskCompleted:
+		return "completed"
+	case TaskFailed:
+		return "failed"
+	case TaskCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
+
+// ParseTaskStatus returns the status with the given name.
+func ParseTaskStatus(name string) (TaskStatus, error) {
+	for s := TaskPending; s <= TaskCancelled; s++ {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown task status %q", name)
+}
+
 var taskPool = &sync.Pool{
 	New: func() any {
 		return &Task{}
@@ -18,7 +54,48 @@ type Task struct {
 	Parameters map[string]string
 	At         time.Time
 	Completed  bool
+	Status     TaskStatus
 	Retries    int
+	// Trace is the W3C traceparent of the span that registered the task.
+	Trace string
+	// Callback is the url notified about the result of the delivery.
+	Callback string
+	// Labels are not sent to the sink, they let producers find their tasks.
+	Labels map[string]string
+	// IdempotencyKey makes registering the same task again return the first one.
+	IdempotencyKey string
+	// GroupKey is the group of a task merged with others of its group.
+	GroupKey string
+	// Digest lists the tasks merged into this one, it is delivered instead of
+	// Parameters.
+	Digest Digest
+
+	ctx      context.Context
+	response *Response
+	// receives the result of registration when the producer waits for it
+	registered chan error
+	// group of a task delivered for it and the key stored once it is
+	group string
+	slot  string
+	// set by registration when the task was stored, not replayed by its
+	// idempotency key or grouped into another task
+	created bool
+}
+
+// SetResponse records what the sink answered, it is kept in the attempt history.
+func (t *Task) SetResponse(statusCode int, body []byte) {
+	t.response = &Response{
+		StatusCode: statusCode,
+		Body:       body,
+	}
+}
+
+// Context returns the context of the task delivery, carrying its span.
+func (t *Task) Context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
 }
 
 func (t *Task) Dispose() {
@@ -27,6 +104,19 @@ func (t *Task) Dispose() {
 	t.Parameters = make(map[string]string)
 	t.At = time.Time{}
 	t.Completed = false
+	t.Status = TaskPending
+	t.Retries = 0
+	t.Trace = ""
+	t.Callback = ""
+	t.Labels = nil
+	t.IdempotencyKey = ""
+	t.GroupKey = ""
+	t.Digest = nil
+	t.group = ""
+	t.slot = ""
+	t.created = false
+	t.ctx = nil
+	t.response = nil
 	taskPool.Put(t)
 }
 
@@ -37,6 +127,9 @@ func NewTask(id int, method string, params map[string]string, at time.Time, comp
 	t.Parameters = params
 	t.At = at
 	t.Completed = completed
+	if completed {
+		t.Status = TaskCompleted
+	}
 	return t
 }
 
@@ -51,6 +144,11 @@ func (t *Task) fromTask() *Task {
 	tt.Parameters = t.Parameters
 	tt.At = t.At
 	tt.Completed = t.Completed
+	tt.Status = t.Status
+	tt.Trace = t.Trace
+	tt.Callback = t.Callback
+	tt.Labels = t.Labels
+	tt.IdempotencyKey = t.IdempotencyKey
 	return tt
 }
 
@@ -66,6 +164,22 @@ func (t *Task) markAsFailed(tx Transaction) (Result, error) {
 	return tx.IncrementRetries(t.Id)
 }
 
+func (t *Task) retryAt(tx Transaction, at time.Time) (Result, error) {
+	return tx.RetryTask(t.Id, at)
+}
+
+func (t *Task) markAsPermanentlyFailed(tx Transaction) (Result, error) {
+	return tx.FailTask(t.Id)
+}
+
+func (t *Task) recordAttempt(tx Transaction, a *Attempt) (Result, error) {
+	return tx.InsertAttempt(a)
+}
+
+func (t *Task) notify(tx Transaction, cb *Callback) (Result, error) {
+	return tx.InsertCallback(cb)
+}
+
 func (t *Task) key(params []string, tformat string) []byte {
 	var sb bytes.Buffer
 	sb.WriteString(t.Method)
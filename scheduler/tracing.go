@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName  = "github.com/gosched/scheduler"
+	traceParent = "traceparent"
+)
+
+var propagator = propagation.TraceContext{}
+
+// WithTracerProvider sets the provider of spans, the global one is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Scheduler) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+func defaultTracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// encodeTrace returns the W3C traceparent of the span in ctx.
+func encodeTrace(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(traceParent)
+}
+
+// decodeTrace returns ctx carrying the remote span described by a traceparent.
+func decodeTrace(ctx context.Context, tp string) context.Context {
+	if tp == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{traceParent: tp})
+}
+
+// InjectTrace writes trace context of the task delivery to outgoing headers.
+func InjectTrace(t *Task, carrier propagation.TextMapCarrier) {
+	propagator.Inject(t.Context(), carrier)
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
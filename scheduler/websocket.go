@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/gosched/scheduler/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Messages exchanged over the websocket. Tasks, filters and events are encoded
+// with protojson, exactly as in the http api.
+const (
+	wsRegister    = "register"
+	wsSubscribe   = "subscribe"
+	wsUnsubscribe = "unsubscribe"
+	wsAck         = "ack"
+	wsError       = "error"
+	wsEvent       = "event"
+)
+
+type wsRequest struct {
+	Type string `json:"type"`
+	// Id is chosen by the client and repeated in the response, for
+	// subscriptions it identifies the subscription in events.
+	Id     string          `json:"id"`
+	Task   json.RawMessage `json:"task,omitempty"`
+	Filter json.RawMessage `json:"filter,omitempty"`
+}
+
+type wsResponse struct {
+	Type string `json:"type"`
+	Id   string `json:"id,omitempty"`
+	// TaskId is the id of a registered task, sent with the ack of register.
+	TaskId int64           `json:"task_id,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Event  json.RawMessage `json:"event,omitempty"`
+}
+
+// websocketHandler lets clients register tasks and watch events over a single connection.
+type websocketHandler struct {
+	srv    *Server
+	logger *slog.Logger
+}
+
+func newWebsocketHandler(srv *Server, logger *slog.Logger) *websocketHandler {
+	return &websocketHandler{
+		srv:    srv,
+		logger: logger,
+	}
+}
+
+func (h *websocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		h.logger.Error("error accepting websocket", slog.Any("err", err))
+		return
+	}
+	defer c.CloseNow()
+
+	conn := &wsConn{
+		c:    c,
+		subs: make(map[string]*subscription),
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer conn.unsubscribeAll(h.srv.events)
+
+	for {
+		_, data, err := c.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) != websocket.StatusNormalClosure && !errors.Is(err, context.Canceled) {
+				h.logger.Debug("websocket closed", slog.Any("err", err))
+			}
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			conn.write(ctx, wsResponse{Type: wsError, Error: err.Error()})
+			continue
+		}
+
+		ack, err := h.handle(ctx, conn, req)
+		if err != nil {
+			conn.write(ctx, wsResponse{Type: wsError, Id: req.Id, Error: err.Error()})
+			continue
+		}
+		conn.write(ctx, ack)
+	}
+}
+
+// handle returns the ack of the request.
+func (h *websocketHandler) handle(ctx context.Context, conn *wsConn, req wsRequest) (wsResponse, error) {
+	ack := wsResponse{Type: wsAck, Id: req.Id}
+	switch req.Type {
+	case wsRegister:
+		pbt := &pb.Task{}
+		if err := protojson.Unmarshal(req.Task, pbt); err != nil {
+			return ack, err
+		}
+		res, err := h.srv.Register(ctx, pbt)
+		if err != nil {
+			return ack, err
+		}
+		ack.TaskId = res.Id
+		return ack, nil
+	case wsSubscribe:
+		filter := &pb.WatchRequest{}
+		if len(req.Filter) > 0 {
+			if err := protojson.Unmarshal(req.Filter, filter); err != nil {
+				return ack, err
+			}
+		}
+		return ack, conn.subscribe(ctx, h.srv.events, req.Id, filter)
+	case wsUnsubscribe:
+		return ack, conn.unsubscribe(h.srv.events, req.Id)
+	}
+	return ack, errors.New("unknown message type")
+}
+
+type wsConn struct {
+	c *websocket.Conn
+
+	wmu sync.Mutex
+	mu  sync.Mutex
+	// subscriptions by the id chosen by the client
+	subs map[string]*subscription
+}
+
+func (conn *wsConn) write(ctx context.Context, res wsResponse) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	conn.wmu.Lock()
+	defer conn.wmu.Unlock()
+	return conn.c.Write(ctx, websocket.MessageText, data)
+}
+
+func (conn *wsConn) subscribe(ctx context.Context, bus *eventBus, id string, req *pb.WatchRequest) error {
+	if id == "" {
+		return errors.New("empty subscription id")
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if _, ok := conn.subs[id]; ok {
+		return errors.New("subscription already exists")
+	}
+
+	sub := bus.subscribe(EventFilter{
+		Method: req.Method,
+		TaskId: int(req.TaskId),
+		Labels: req.Labels,
+	})
+	conn.subs[id] = sub
+
+	go func() {
+		for {
+			select {
+			case e, ok := <-sub.ch:
+				if !ok {
+					return
+				}
+				data, err := protojson.Marshal(toPbEvent(e))
+				if err != nil {
+					continue
+				}
+				if err := conn.write(ctx, wsResponse{Type: wsEvent, Id: id, Event: data}); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (conn *wsConn) unsubscribe(bus *eventBus, id string) error {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	sub, ok := conn.subs[id]
+	if !ok {
+		return errors.New("unknown subscription")
+	}
+	bus.unsubscribe(sub)
+	close(sub.ch)
+	delete(conn.subs, id)
+	return nil
+}
+
+func (conn *wsConn) unsubscribeAll(bus *eventBus) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	for id, sub := range conn.subs {
+		bus.unsubscribe(sub)
+		delete(conn.subs, id)
+	}
+}
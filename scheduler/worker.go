@@ -2,12 +2,17 @@ package scheduler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/VictoriaMetrics/fastcache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -20,192 +25,267 @@ const (
 )
 
 type worker struct {
+	id int
 	db Database
 
-	ticker   *time.Ticker
+	tasks    chan *Task
 	exitChan chan struct{}
 	logger   *slog.Logger
-	ttime    time.Duration
+	m        *workerManager
 	// shared among workers
-	cache         *fastcache.Cache
-	h             Handler
+	dedup         DedupStore
+	txDedup       bool
+	settings      *atomic.Pointer[settings]
 	batchLiveTime *time.Ticker // time after which batch will by commited manually
 	batch         *batch
 	batchSize     int
-	strategy      map[string]GroupingStrategy
 	bmu           sync.Mutex
-	grouped       chan []byte
+	total         stats
 }
 
-func (s *Scheduler) newWorker() (*worker, error) {
-	w := &worker{
+func (s *Scheduler) newWorker(id int, m *workerManager) *worker {
+	queueSize := s.opts.batchSize * workerQueueFactor
+	if queueSize <= 0 {
+		queueSize = initialBatchSize * workerQueueFactor
+	}
+	return &worker{
+		id:            id,
 		db:            s.db,
+		tasks:         make(chan *Task, queueSize),
 		exitChan:      make(chan struct{}),
-		logger:        s.logger,
-		h:             s.handler,
+		logger:        s.logger.With(slog.Int("worker", id)),
+		m:             m,
+		settings:      &s.settings,
 		batchLiveTime: time.NewTicker(btime),
 		batchSize:     s.opts.batchSize,
-		strategy:      s.opts.groupingStrategy,
-		grouped:       make(chan []byte),
-		cache:         s.cache,
-	}
-	if s.opts.ticker != nil {
-		w.ttime = *s.opts.ticker
-	} else {
-		w.ttime = ttime
+		dedup:         s.dedup,
+		txDedup:       s.txDedup(),
 	}
-	w.ticker = time.NewTicker(w.ttime)
-	err := w.initCache()
-	if err != nil {
-		return nil, err
-	}
-	return w, nil
 }
 
-func (w *worker) initCache() error {
-	rows, err := w.db.GetProcessed()
-	if err != nil {
-		return err
+func (w *worker) start() {
+	for {
+		select {
+		case t := <-w.tasks:
+			w.finishTask(t)
+		case <-w.batchLiveTime.C:
+			w.logger.Debug("im in batch ticker to commit")
+			w.commitBatch(w.batch)
+		case <-w.exitChan:
+			return
+		}
 	}
-	var (
-		key []byte
-	)
+}
 
-	var (
-		keys []string
-	)
+func (w *worker) finishTask(t *Task) {
+	if w.batch == nil {
+		w.batch = newBatch(w.batchSize, w.settings.Load(), w.dedup, &w.m.claims)
+	}
 
-	defer rows.Close()
+	if w.batch.ready() {
+		w.commitBatch(w.batch)
+	}
 
-	for rows.Next() {
-		if err := rows.Scan(&key); err != nil {
-			return err
+	if err := w.batch.add(t); err != nil {
+		// leave the task for the next scan, when its group is known or
+		// there are tokens left
+		switch {
+		case errors.Is(err, errRateExceeded):
+			w.logger.Debug("rate limit exceeded, task deferred",
+				slog.Int("task", t.Id),
+				slog.String("method", t.Method))
+		case errors.Is(err, errGroupBusy):
+			w.logger.Debug("group is being delivered, task deferred",
+				slog.Int("task", t.Id),
+				slog.String("method", t.Method))
+		default:
+			w.logger.Error("couldn't check grouping key, task deferred",
+				slog.Int("task", t.Id),
+				slog.Any("err", err))
 		}
-
-		w.cache.Set(key, nil)
-		keys = append(keys, string(key))
+		w.m.metrics.deferred.WithLabelValues(t.Method).Inc()
+		w.m.done(t.Id)
+		t.Dispose()
 	}
+}
 
-	w.logger.Debug("initialized cache",
-		slog.Any("keys", keys))
+// errDeferred is the outcome of a task left for the next scan by delivery
+// limits.
+var errDeferred = errors.New("delivery deferred by limits")
 
-	return nil
+// isDeferred reports whether the task wasn't delivered and stays pending
+// without using a retry.
+func isDeferred(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, errDeferred)
 }
 
-func (w *worker) findTasks() ([]*Task, error) {
-	tt := time.Now()
+// outcome is the result of a single delivery. Outcomes are written to the
+// database only after every delivery of the batch has finished.
+type outcome struct {
+	t   *Task
+	err error
 
-	res, err := w.db.FindNotCompleted(tt)
-	if err != nil {
-		return nil, err
-	}
+	start time.Time
+	end   time.Time
+}
 
-	defer func() {
-		err = res.Close()
-		if err != nil {
-			w.logger.Error("error while closing iterator", slog.Any("error", err))
+func (w *worker) handleTaskInternal(ctx context.Context, st *settings, s *stats, o *outcome) func() error {
+	return func() error {
+		defer st.release(o.t)
+		if err := st.wait(ctx, o.t); err != nil {
+			o.err = fmt.Errorf("%w: %w", errDeferred, err)
+			w.deferred(s, o.t)
+			return nil
 		}
-	}()
-
-	var (
-		tasks []*Task
-	)
-
-	for res.Next() {
-		t := EmptyTask()
-		err := res.Into(t)
-		if err != nil {
-			return nil, err
+		// delivery may happen hours after registration, so the registration
+		// span is linked instead of being the parent
+		ctx, span := w.m.tracer.Start(ctx, "Handler.Handle",
+			trace.WithLinks(trace.LinkFromContext(decodeTrace(context.Background(), o.t.Trace))),
+			trace.WithAttributes(
+				attribute.Int("task", o.t.Id),
+				attribute.String("method", o.t.Method)))
+		defer span.End()
+		o.t.ctx = ctx
+
+		w.m.events.publish(newEvent(EventDispatched, o.t))
+		o.start = time.Now()
+		o.err = st.handler.Handle(o.t)
+		o.end = time.Now()
+		if o.err != nil {
+			recordError(span, o.err)
 		}
-
-		if t.Retries >= maxRetries {
-			w.logger.Error("max retries exceeded", slog.Int("task", t.Id))
-			continue
+		if errors.Is(o.err, ErrCircuitOpen) {
+			// sink is down, leave the task for the next scan without using a retry
+			w.deferred(s, o.t)
+			return nil
 		}
-
-		tasks = append(tasks, t)
+		w.m.metrics.observeDelivery(o.t, o.start, o.err)
+		s.add(o.err == nil)
+		w.total.add(o.err == nil)
+		// failures are recorded per task and never abort the batch
+		return nil
 	}
+}
 
-	if err = res.Err(); err != nil {
-		return nil, err
-	}
+// deferred counts a task left for the next scan.
+func (w *worker) deferred(s *stats, t *Task) {
+	w.m.metrics.deferred.WithLabelValues(t.Method).Inc()
+	s.deferred.Add(1)
+	w.total.deferred.Add(1)
+}
 
-	return tasks, nil
+func (w *worker) markAsFailed(tx Transaction, t *Task, herr error) error {
+	var err error
+	switch after := retryAfter(herr); {
+	case isPermanent(herr):
+		w.logger.Error("task failed permanently",
+			slog.Int("task", t.Id),
+			slog.Any("error", herr))
+		_, err = t.markAsPermanentlyFailed(tx)
+	case t.Retries+1 >= maxRetries:
+		// the last retry, the task is dead lettered
+		w.logger.Error("max retries exceeded",
+			slog.Int("task", t.Id),
+			slog.Any("error", herr))
+		if _, err = t.markAsFailed(tx); err == nil {
+			_, err = t.markAsPermanentlyFailed(tx)
+		}
+	case after > 0:
+		_, err = t.retryAt(tx, time.Now().Add(after))
+	default:
+		_, err = t.markAsFailed(tx)
+	}
+	return err
 }
 
-func (w *worker) start() {
-	go w.groupedWorker()
-	for {
-		select {
-		case <-w.ticker.C:
-			w.logger.Debug("im in ticker")
-			tt, err := w.findTasks()
+// apply writes outcomes of the batch to the transaction and returns tasks
+// marked as grouped. Any error aborts the whole transaction, so either every
+// outcome is persisted or none is.
+func (w *worker) apply(tx Transaction, b *batch, outcomes []outcome) ([]*Task, error) {
+	h := b.settings.handler
+	now := time.Now()
+	store, inTx := w.dedupTx(tx)
+	grouped := slices.Clone(b.excluded)
+	// groups whose tasks weren't all delivered
+	failed := make(map[string]bool)
+	for i := range outcomes {
+		o := &outcomes[i]
+		if o.err != nil && o.t.group != "" {
+			failed[o.t.group] = true
+		}
+		if isDeferred(o.err) {
+			continue
+		}
+		a := newAttempt(h, o)
+		if _, err := o.t.recordAttempt(tx, a); err != nil {
+			return nil, fmt.Errorf("recording attempt of task %d: %w", o.t.Id, err)
+		}
+		if o.t.Callback != "" {
+			cb, err := newCallback(o, a)
 			if err != nil {
-				w.logger.Error("error while finding tasks", slog.Any("err", err))
-				w.ticker.Reset(ttime)
-				continue
+				return nil, fmt.Errorf("creating callback of task %d: %w", o.t.Id, err)
 			}
-			w.logger.Debug("found some tasks", slog.Int("number_of_tasks", len(tt)))
-			for _, t := range tt {
-				w.finishTask(t)
+			if _, err := o.t.notify(tx, cb); err != nil {
+				return nil, fmt.Errorf("storing callback of task %d: %w", o.t.Id, err)
 			}
-		case <-w.batchLiveTime.C:
-			w.logger.Debug("im in batch ticker to commit")
-			w.commitBatch(w.batch)
-		case <-w.exitChan:
-			return
 		}
-	}
-}
-
-func (w *worker) groupedWorker() {
-	w.logger.Debug("[groupedWorker] starting]")
-	for key := range w.grouped {
-		w.logger.Debug("[groupedWorker] found some grouped task", slog.String("key", string(key)))
-		res, err := w.db.InsertProcessed(key)
-		if err != nil {
-			w.logger.Error("error while inserting processed", slog.Any("error", err))
-		} else {
-			id, _ := res.LastInsertId()
-			w.logger.Debug("inserted processed key",
-				slog.String("key", string(key)),
-				slog.Int64("id", id))
+		switch {
+		case o.err == nil:
+			if _, err := o.t.markAsDone(tx); err != nil {
+				return nil, fmt.Errorf("marking task %d as done: %w", o.t.Id, err)
+			}
+			if inTx && o.t.group != "" {
+				if err := w.storeGroup(store, b, o.t, now); err != nil {
+					return nil, fmt.Errorf("storing group of task %d: %w", o.t.Id, err)
+				}
+			}
+		default:
+			// the key isn't stored, tasks following a task that failed
+			// permanently are delivered instead
+			if err := w.markAsFailed(tx, o.t, o.err); err != nil {
+				return nil, fmt.Errorf("marking task %d as failed: %w", o.t.Id, err)
+			}
 		}
 	}
-}
-
-func (w *worker) finishTask(t *Task) {
-	if w.batch == nil {
-		w.batch = newBatch(w.batchSize, w.strategy, w.cache, w.grouped)
+	for group, followers := range b.followers {
+		if !failed[group] {
+			grouped = append(grouped, followers...)
+		}
 	}
-
-	if w.batch.ready() {
-		w.commitBatch(w.batch)
+	for _, t := range grouped {
+		if _, err := t.markAsDone(tx); err != nil {
+			return nil, fmt.Errorf("marking excluded task %d: %w", t.Id, err)
+		}
 	}
+	return grouped, nil
+}
 
-	w.batch.add(t)
+// dedupTx returns the transaction as the dedup store when grouping keys are
+// stored by delivery transactions.
+func (w *worker) dedupTx(tx Transaction) (DedupStore, bool) {
+	store, ok := tx.(DedupStore)
+	return store, ok && w.txDedup
 }
 
-func (w *worker) handleTaskInternal(tx Transaction, s *stats, t *Task) func() error {
-	return func() error {
-		err := w.h.Handle(t)
-		s.add(err == nil)
-		if err == nil {
-			_, err = t.markAsDone(tx)
-			if err != nil {
-				w.logger.Error("error while marking task as done", slog.Any("error", err))
-			}
-		} else {
-			_, err = t.markAsFailed(tx)
-			if err != nil {
-				w.logger.Error("error while marking task as failed", slog.Any("error", err))
-			}
-		}
-		t.Dispose()
+// storeGroup stores the group of a delivered task. A group stored meanwhile
+// means it was delivered by another scheduler sharing the store.
+func (w *worker) storeGroup(store DedupStore, b *batch, t *Task, now time.Time) error {
+	stored, err := store.SetIfAbsent(t.slot, b.ttl(t, now))
+	if err != nil {
 		return err
 	}
+	if !stored {
+		w.logger.Warn("group was delivered concurrently",
+			slog.Int("task", t.Id),
+			slog.String("key", t.slot))
+	}
+	return nil
 }
 
+// commitBatch delivers tasks of the batch and records the results in a single
+// transaction. Delivery is at-least-once: when the transaction can't be
+// committed it is rolled back and every task of the batch, including the ones
+// already delivered, stays pending and is delivered again on the next scan.
 func (w *worker) commitBatch(b *batch) error {
 	if b == nil {
 		return nil
@@ -215,7 +295,23 @@ func (w *worker) commitBatch(b *batch) error {
 	defer w.bmu.Unlock()
 
 	defer func() {
-		b.reset()
+		for _, t := range b.tasks {
+			w.m.done(t.Id)
+			t.Dispose()
+		}
+		for _, t := range b.excluded {
+			w.m.done(t.Id)
+			t.Dispose()
+		}
+		// followers of tasks that weren't delivered stay pending
+		for _, followers := range b.followers {
+			for _, t := range followers {
+				w.m.done(t.Id)
+				t.Dispose()
+			}
+		}
+		// the next batch starts with the current settings
+		b.reset(w.settings.Load())
 	}()
 
 	if len(b.tasks) == 0 && len(b.excluded) == 0 {
@@ -224,58 +320,175 @@ func (w *worker) commitBatch(b *batch) error {
 
 	now := time.Now()
 
+	ctx, span := w.m.tracer.Start(context.Background(), "worker.commitBatch",
+		trace.WithAttributes(
+			attribute.Int("worker", w.id),
+			attribute.Int("tasks", len(b.tasks)),
+			attribute.Int("grouped", len(b.excluded))))
+	defer span.End()
+
 	it := b.iter()
-	tx, err := w.db.Begin(context.Background())
-	if err != nil {
-		return err
-	}
+	st := b.settings
 
 	var errg errgroup.Group
 	errg.SetLimit(gorutinesHandlerLimit)
 	s := &stats{}
-	for it.hasNext() {
-		t := it.next()
-		errg.Go(w.handleTaskInternal(tx, s, t))
+	var (
+		outcomes = make([]outcome, b.size())
+		waiting  []*outcome
+		// tasks started by method, the ones over the burst wait for tokens
+		started = make(map[string]int)
+		total   int
+	)
+	for i := 0; it.hasNext(); i++ {
+		o := &outcomes[i]
+		o.t = it.next()
+		if !st.admits(o.t, started[o.t.Method], total, 0) {
+			waiting = append(waiting, o)
+			continue
+		}
+		if !st.tryAcquire(o.t) {
+			w.deferTask(b, s, o)
+			continue
+		}
+		started[o.t.Method]++
+		total++
+		errg.Go(w.handleTaskInternal(ctx, st, s, o))
 	}
 
-	if len(b.excluded) > 0 {
-		errg.Go(func() error {
-			for _, t := range b.excluded {
-				_, err = t.markAsDone(tx)
-				if err != nil {
-					w.logger.Error("error while marking excluded",
-						slog.Any("error", err))
-				}
-			}
-			return nil
-		})
+	// tasks waiting for a rate token are started last, so they don't hold
+	// back the other methods of the batch
+	for _, o := range waiting {
+		if !st.tryAcquire(o.t) {
+			w.deferTask(b, s, o)
+			continue
+		}
+		errg.Go(w.handleTaskInternal(ctx, st, s, o))
 	}
 
-	err = errg.Wait()
+	// handlers never return errors, failures are kept in outcomes
+	_ = errg.Wait()
+
+	dbStart := time.Now()
+	defer w.m.metrics.observeQuery("commit_batch", dbStart)
+
+	tx, err := w.db.Begin(ctx)
 	if err != nil {
-		w.logger.Error("error handling task", slog.Any("err", err))
-		return nil
+		w.logger.Error("error beginning transaction, batch will be redelivered",
+			slog.Any("err", err))
+		recordError(span, err)
+		return err
+	}
+
+	grouped, err := w.apply(tx, b, outcomes)
+	if err != nil {
+		w.logger.Error("error applying batch, rolling back", slog.Any("err", err))
+		recordError(span, err)
+		if rerr := tx.Rollback(); rerr != nil {
+			w.logger.Error("error rolling back", slog.Any("err", rerr))
+		}
+		return err
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		w.logger.Error("error commiting", slog.Any("err", err))
+		w.logger.Error("error commiting, rolling back", slog.Any("err", err))
+		recordError(span, err)
+		// the failed commit may have already closed the transaction
+		_ = tx.Rollback()
 		return err
 	}
 
+	if _, inTx := w.dedupTx(tx); !inTx {
+		w.storeGroups(b, outcomes)
+	}
+
+	w.total.grouped.Add(uint64(len(grouped)))
+	w.observe(outcomes, grouped)
+
 	w.logger.Debug("commited batch",
 		slog.Float64("time s", time.Since(now).Seconds()),
-		slog.Uint64("total", uint64(s.total.Load())),
-		slog.Uint64("succeed", uint64(s.succeed.Load())),
-		slog.Uint64("failed", uint64(s.failed.Load())),
-		slog.Int("grouped", len(b.excluded)))
+		slog.Uint64("total", s.total.Load()),
+		slog.Uint64("succeed", s.succeed.Load()),
+		slog.Uint64("failed", s.failed.Load()),
+		slog.Int("grouped", len(grouped)),
+		slog.Uint64("deferred", s.deferred.Load()),
+		slog.String("breaker", w.breakerState()),
+		slog.Uint64("worker_total", w.total.total.Load()),
+		slog.Uint64("worker_succeed", w.total.succeed.Load()),
+		slog.Uint64("worker_failed", w.total.failed.Load()))
 	return nil
 }
 
+// deferTask leaves a task without a free in-flight slot for the next scan
+// instead of waiting for one, so a slow method doesn't hold back the worker.
+// Its group is released, other tasks of the group stay pending with it.
+func (w *worker) deferTask(b *batch, s *stats, o *outcome) {
+	w.logger.Debug("in-flight limit reached, task deferred",
+		slog.Int("task", o.t.Id),
+		slog.String("method", o.t.Method))
+	o.err = errDeferred
+	b.release(o.t.slot)
+	w.deferred(s, o.t)
+}
+
+// storeGroups stores groups of delivered tasks in a store outside of the
+// database. A crash before they are stored lets the group be delivered again.
+func (w *worker) storeGroups(b *batch, outcomes []outcome) {
+	now := time.Now()
+	for _, o := range outcomes {
+		if o.err != nil || o.t.group == "" {
+			continue
+		}
+		if err := w.storeGroup(w.dedup, b, o.t, now); err != nil {
+			w.logger.Error("error while storing group",
+				slog.Int("task", o.t.Id),
+				slog.Any("err", err))
+		}
+	}
+}
+
+// observe records metrics and publishes events of a committed batch.
+func (w *worker) observe(outcomes []outcome, excluded []*Task) {
+	mm := w.m.metrics
+	mm.batchSize.WithLabelValues(strconv.Itoa(w.id)).Set(float64(len(outcomes) + len(excluded)))
+	for _, o := range outcomes {
+		switch {
+		case isDeferred(o.err):
+		case o.err == nil:
+			w.m.events.publish(newEvent(EventSucceeded, o.t))
+		case isPermanent(o.err) || o.t.Retries+1 >= maxRetries:
+			mm.deadLettered.WithLabelValues(o.t.Method).Inc()
+			w.publishFailure(EventFailed, o)
+		default:
+			w.publishFailure(EventRetried, o)
+		}
+	}
+	for _, t := range excluded {
+		mm.grouped.WithLabelValues(t.Method).Inc()
+		w.m.events.publish(newEvent(EventGrouped, t))
+	}
+}
+
+func (w *worker) publishFailure(typ EventType, o outcome) {
+	e := newEvent(typ, o.t)
+	e.Error = o.err.Error()
+	w.m.events.publish(e)
+}
+
+func (w *worker) breakerState() string {
+	if cb, ok := w.settings.Load().handler.(*CircuitBreaker); ok {
+		return cb.State().String()
+	}
+	return "none"
+}
+
 type stats struct {
-	total   atomic.Uint32
-	succeed atomic.Uint32
-	failed  atomic.Uint32
+	total    atomic.Uint64
+	succeed  atomic.Uint64
+	failed   atomic.Uint64
+	grouped  atomic.Uint64
+	deferred atomic.Uint64
 }
 
 func (s *stats) add(ok bool) {
@@ -0,0 +1,114 @@
+package sqlitedb
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+type column struct {
+	name       string
+	definition string
+}
+
+// taskColumns are columns added to the tasks table after its initial schema.
+var taskColumns = []column{
+	{name: "trace", definition: `TEXT NOT NULL DEFAULT ''`},
+	{name: "callback", definition: `TEXT NOT NULL DEFAULT ''`},
+	{name: "labels", definition: `TEXT NOT NULL DEFAULT '{}'`},
+	{name: "idempotency_key", definition: `TEXT NOT NULL DEFAULT ''`},
+	{name: "group_key", definition: `TEXT NOT NULL DEFAULT ''`},
+	{name: "digest", definition: `TEXT NOT NULL DEFAULT ''`},
+}
+
+// processedColumns are columns added to the processed table after its
+// initial schema.
+var processedColumns = []column{
+	{name: "expires_at", definition: `datetime`},
+}
+
+// migrate creates missing tables and adds columns missing in databases
+// created by older versions.
+func migrate(db *sql.DB) error {
+	stmts := []string{
+		createTaskTable,
+		createProcessed,
+		createAttempts,
+		indexAttempts,
+		createCallbacks,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := addColumns(db, "tasks", taskColumns); err != nil {
+		return err
+	}
+	added, err := addColumns(db, "processed", processedColumns)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(added, "expires_at") {
+		// keys of older versions never expired, they are kept long enough for
+		// windows up to a month, since their windows are unknown
+		if _, err := db.Exec(backfillExpires); err != nil {
+			return err
+		}
+	}
+	// indexes need the columns added above, older versions could store a
+	// processed key more than once
+	stmts = []string{
+		indexIdempotent,
+		indexGroup,
+		dedupProcessed,
+		indexProcessed,
+		indexExpires,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumns adds missing columns to the table and returns their names.
+func addColumns(db *sql.DB, table string, columns []column) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%q)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid        int
+			name, typ  string
+			notNull    bool
+			dflt       sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &primaryKey); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var added []string
+	for _, c := range columns {
+		if existing[c.name] {
+			continue
+		}
+		_, err := db.Exec(fmt.Sprintf("ALTER TABLE %q ADD COLUMN %q %s", table, c.name, c.definition))
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, c.name)
+	}
+	return added, nil
+}
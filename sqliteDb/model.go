@@ -12,8 +12,16 @@ type Task struct {
 	Method     string
 	Parameters sql.RawBytes
 	At         time.Time
-	Completed  bool
+	Status     int
 	Retries    int
+	Trace      string
+	Callback   string
+	Labels     sql.RawBytes
+	// IdempotencyKey is only written, tasks are looked up by it.
+	IdempotencyKey string
+	GroupKey       string
+	// Digest is empty for tasks that aren't merged.
+	Digest sql.RawBytes
 }
 
 func fromSchedulerTask(task *scheduler.Task) (*Task, error) {
@@ -21,12 +29,30 @@ func fromSchedulerTask(task *scheduler.Task) (*Task, error) {
 	if err != nil {
 		return nil, err
 	}
+	labels, err := json.Marshal(task.Labels)
+	if err != nil {
+		return nil, err
+	}
+	var digest []byte
+	if task.Digest != nil {
+		digest, err = json.Marshal(task.Digest)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &Task{
 		Id:         task.Id,
 		Method:     task.Method,
 		Parameters: data,
 		At:         task.At,
-		Completed:  task.Completed,
+		Status:     int(task.Status),
+		Trace:      task.Trace,
+		Callback:   task.Callback,
+		Labels:     labels,
+
+		IdempotencyKey: task.IdempotencyKey,
+		GroupKey:       task.GroupKey,
+		Digest:         digest,
 	}, nil
 }
 
@@ -41,6 +67,23 @@ func toSchedulerTask(task *Task, schedulerTask *scheduler.Task) error {
 	schedulerTask.Method = task.Method
 	schedulerTask.Parameters = data
 	schedulerTask.At = task.At
-	schedulerTask.Completed = task.Completed
+	schedulerTask.Status = scheduler.TaskStatus(task.Status)
+	schedulerTask.Completed = schedulerTask.Status == scheduler.TaskCompleted
+	schedulerTask.Retries = task.Retries
+	schedulerTask.Trace = task.Trace
+	schedulerTask.Callback = task.Callback
+	schedulerTask.Labels = nil
+	if len(task.Labels) > 0 {
+		if err := json.Unmarshal(task.Labels, &schedulerTask.Labels); err != nil {
+			return err
+		}
+	}
+	schedulerTask.GroupKey = task.GroupKey
+	schedulerTask.Digest = nil
+	if len(task.Digest) > 0 {
+		if err := json.Unmarshal(task.Digest, &schedulerTask.Digest); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -3,28 +3,63 @@ package sqlitedb
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gosched/scheduler"
 )
 
 var (
-	_ scheduler.Database    = (*sqliteHandler)(nil)
-	_ scheduler.Transaction = (*transaction)(nil)
-	_ scheduler.Transaction = (*singleTransaction)(nil)
+	_ scheduler.Database       = (*sqliteHandler)(nil)
+	_ scheduler.DedupStore     = (*sqliteHandler)(nil)
+	_ scheduler.DedupCompactor = (*sqliteHandler)(nil)
+	_ scheduler.Transaction    = (*transaction)(nil)
+	_ scheduler.Transaction    = (*singleTransaction)(nil)
+	_ scheduler.DedupStore     = (*transaction)(nil)
 )
 
 const (
-	selectTask      = "SELECT * from tasks WHERE at < ? and (completed=0 or completed is null)"
-	insertTask      = "INSERT INTO tasks(method, parameters, at) VALUES(?, ?, ?)"
+	taskFields      = "id, method, parameters, at, completed, retries, trace, callback, labels, group_key, digest"
+	selectTask      = "SELECT " + taskFields + " from tasks WHERE at < ? and (completed=0 or completed is null)"
+	insertTask      = "INSERT INTO tasks(method, parameters, at, trace, callback, labels, idempotency_key, group_key, digest) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)"
 	updateTask      = "UPDATE tasks SET completed=1 where id=?"
-	createTaskTable = `CREATE TABLE "tasks" ("id" integer,"method" TEXT NOT NULL,"parameters" TEXT NOT NULL,"at" datetime NOT NULL, "completed" INTEGER NOT NULL DEFAULT 0, "retries" INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (id));`
-	incrRetries     = "UPDATE tasks SET retires = retries+1 WHERE id=?"
-	createProcessed = `CREATE TABLE processed("id" integer , "key" TEXT not null, "at" datetime not null default CURRENT_TIMESTAMP, PRIMARY KEY (id));`
-	insertProcessed = "INSERT INTO processed(key) VALUES(?)"
-	getProcessed    = "SELECT key FROM processed"
+	createTaskTable = `CREATE TABLE IF NOT EXISTS "tasks" ("id" integer,"method" TEXT NOT NULL,"parameters" TEXT NOT NULL,"at" datetime NOT NULL, "completed" INTEGER NOT NULL DEFAULT 0, "retries" INTEGER NOT NULL DEFAULT 0, PRIMARY KEY (id));`
+	incrRetries     = "UPDATE tasks SET retries = retries+1 WHERE id=?"
+	retryTask       = "UPDATE tasks SET retries = retries+1, at=? WHERE id=?"
+	failTask        = "UPDATE tasks SET completed=2 WHERE id=?"
+	cancelTask      = "UPDATE tasks SET completed=3 WHERE id=? and completed=0"
+	getTask         = "SELECT " + taskFields + " from tasks WHERE id=?"
+	listTasks       = "SELECT " + taskFields + " from tasks"
+	requeueTask     = "UPDATE tasks SET completed=0, retries=0, at=? WHERE id=? and completed in (2, 3)"
+	countTasks      = "SELECT completed, count(*) FROM tasks GROUP BY completed"
+	findIdempotent  = "SELECT id from tasks WHERE idempotency_key=?"
+	indexIdempotent = `CREATE UNIQUE INDEX IF NOT EXISTS tasks_idempotency_key ON tasks(idempotency_key) WHERE idempotency_key != '';`
+	findGroup       = "SELECT id from tasks WHERE group_key=? and completed=0 and at > ? ORDER BY at LIMIT 1"
+	mergeTask       = "UPDATE tasks SET digest=json_insert(digest, '$[#]', json(?)) WHERE id=? and completed=0 and at > ?"
+	replaceTask     = "UPDATE tasks SET parameters=?, at=?, trace=?, callback=?, labels=? WHERE id=? and completed=0 and at > ?"
+	lastCompleted   = "SELECT at from tasks WHERE group_key=? and completed=1 ORDER BY at DESC LIMIT 1"
+	indexGroup      = `CREATE INDEX IF NOT EXISTS tasks_group_key ON tasks(group_key) WHERE group_key != '';`
+	createProcessed = `CREATE TABLE IF NOT EXISTS processed("id" integer , "key" TEXT not null, "at" datetime not null default CURRENT_TIMESTAMP, PRIMARY KEY (id));`
+	upsertProcessed = "INSERT INTO processed(key, expires_at) VALUES(?, ?) ON CONFLICT(key) DO UPDATE SET at=CURRENT_TIMESTAMP, expires_at=excluded.expires_at WHERE processed.expires_at < ?"
+	dedupProcessed  = "DELETE FROM processed WHERE id NOT IN (SELECT min(id) FROM processed GROUP BY key)"
+	indexProcessed  = `CREATE UNIQUE INDEX IF NOT EXISTS processed_key ON processed(key);`
+	indexExpires    = `CREATE INDEX IF NOT EXISTS processed_expires_at ON processed(expires_at);`
+	backfillExpires = "UPDATE processed SET expires_at=strftime('%Y-%m-%d %H:%M:%S+00:00', at, '+32 days') WHERE expires_at is null"
+	hasProcessed    = "SELECT count(*) FROM processed WHERE key=? and (expires_at is null or expires_at >= ?)"
+	deleteExpired   = "DELETE FROM processed WHERE id IN (SELECT id FROM processed WHERE expires_at < ? LIMIT ?)"
+	createAttempts  = `CREATE TABLE IF NOT EXISTS attempts("id" integer, "task_id" integer NOT NULL, "attempt" integer NOT NULL, "started_at" datetime NOT NULL, "finished_at" datetime NOT NULL, "handler" TEXT NOT NULL, "status_code" integer NOT NULL DEFAULT 0, "response" TEXT NOT NULL DEFAULT '', "error" TEXT NOT NULL DEFAULT '', PRIMARY KEY (id));`
+	createCallbacks = `CREATE TABLE IF NOT EXISTS callbacks("id" integer, "task_id" integer NOT NULL, "url" TEXT NOT NULL, "payload" TEXT NOT NULL, "attempts" integer NOT NULL DEFAULT 0, "next_at" datetime NOT NULL, "state" integer NOT NULL DEFAULT 0, PRIMARY KEY (id));`
+	insertCallback  = "INSERT INTO callbacks(task_id, url, payload, next_at) VALUES(?, ?, ?, ?)"
+	selectCallbacks = "SELECT id, task_id, url, payload, attempts, next_at FROM callbacks WHERE state=0 and next_at < ?"
+	doneCallback    = "UPDATE callbacks SET state=1, attempts=attempts+1 WHERE id=?"
+	retryCallback   = "UPDATE callbacks SET attempts=attempts+1, next_at=? WHERE id=?"
+	failCallback    = "UPDATE callbacks SET state=2, attempts=attempts+1 WHERE id=?"
+	indexAttempts   = `CREATE INDEX IF NOT EXISTS attempts_task_id ON attempts(task_id);`
+	insertAttempt   = "INSERT INTO attempts(task_id, attempt, started_at, finished_at, handler, status_code, response, error) VALUES(?, ?, ?, ?, ?, ?, ?, ?)"
+	getAttempts     = "SELECT task_id, attempt, started_at, finished_at, handler, status_code, response, error FROM attempts WHERE task_id=? ORDER BY attempt, id"
 )
 
 type sqliteHandler struct {
@@ -44,18 +79,29 @@ func ensureFile(path string) error {
 	return nil
 }
 
+// dsnOptions let workers and registrations write concurrently: readers don't
+// block the writer in WAL mode, writers wait for each other instead of failing
+// with SQLITE_BUSY, and transactions take the write lock when they begin, so
+// it is never upgraded in the middle of a batch.
+const dsnOptions = "?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// NewSqliteHandler opens the database at path. With singleTransactions
+// every statement is committed on its own and Commit and Rollback do nothing.
 func NewSqliteHandler(path string, singleTransactions bool) (*sqliteHandler, error) {
 	err := ensureFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", path)
+	db, err := sql.Open("sqlite3", path+dsnOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	// todo create tables if not exist
+	err = migrate(db)
+	if err != nil {
+		return nil, err
+	}
 
 	return &sqliteHandler{
 		db:                db,
@@ -70,7 +116,7 @@ type it struct {
 func (i *it) Into(task *scheduler.Task) error {
 	tmpTask := &Task{}
 
-	if err := i.Rows.Scan(&tmpTask.Id, &tmpTask.Method, &tmpTask.Parameters, &tmpTask.At, &tmpTask.Completed, &tmpTask.Retries); err != nil {
+	if err := i.Rows.Scan(&tmpTask.Id, &tmpTask.Method, &tmpTask.Parameters, &tmpTask.At, &tmpTask.Status, &tmpTask.Retries, &tmpTask.Trace, &tmpTask.Callback, &tmpTask.Labels, &tmpTask.GroupKey, &tmpTask.Digest); err != nil {
 		return err
 	}
 
@@ -108,18 +154,279 @@ func (h *sqliteHandler) Begin(ctx context.Context) (scheduler.Transaction, error
 	}, nil
 }
 
-func (h *sqliteHandler) InsertProcessed(key any) (scheduler.Result, error) {
-	return h.db.Exec(insertProcessed, key)
+// Has reports whether the grouping key is in the processed table.
+func (h *sqliteHandler) Has(key string) (bool, error) {
+	return hasKey(h.db, key)
+}
+
+// SetIfAbsent stores the grouping key in the processed table, so it is
+// shared by every scheduler using the database.
+func (h *sqliteHandler) SetIfAbsent(key string, ttl time.Duration) (bool, error) {
+	return setIfAbsent(h.db, key, ttl)
+}
+
+type execQuerier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func hasKey(db execQuerier, key string) (bool, error) {
+	var n int
+	if err := db.QueryRow(hasProcessed, key, time.Now().UTC()).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func setIfAbsent(db execQuerier, key string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	var expires any
+	if ttl > 0 {
+		expires = now.Add(ttl)
+	}
+	res, err := db.Exec(upsertProcessed, key, expires, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
 }
 
-func (h *sqliteHandler) GetProcessed() (scheduler.Iterator, error) {
-	rows, err := h.db.Query(getProcessed)
+// DeleteExpired deletes expired grouping keys, keys stored without an expiry
+// are kept.
+func (h *sqliteHandler) DeleteExpired(before time.Time, limit int) (int, error) {
+	res, err := h.db.Exec(deleteExpired, before.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (h *sqliteHandler) GetAttempts(id any) ([]*scheduler.Attempt, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	rows, err := h.db.Query(getAttempts, iid)
 	if err != nil {
 		return nil, err
 	}
-	return &it{
-		Rows: rows,
-	}, nil
+	defer rows.Close()
+
+	var attempts []*scheduler.Attempt
+	for rows.Next() {
+		a := &scheduler.Attempt{}
+		err := rows.Scan(&a.TaskId, &a.Number, &a.StartedAt, &a.FinishedAt, &a.Handler, &a.StatusCode, &a.Response, &a.Error)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+func (h *sqliteHandler) FindPendingCallbacks(at time.Time) ([]*scheduler.Callback, error) {
+	rows, err := h.db.Query(selectCallbacks, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var callbacks []*scheduler.Callback
+	for rows.Next() {
+		cb := &scheduler.Callback{}
+		if err := rows.Scan(&cb.Id, &cb.TaskId, &cb.Url, &cb.Payload, &cb.Attempts, &cb.NextAt); err != nil {
+			return nil, err
+		}
+		callbacks = append(callbacks, cb)
+	}
+	return callbacks, rows.Err()
+}
+
+func (h *sqliteHandler) CompleteCallback(id any) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return h.db.Exec(doneCallback, iid)
+}
+
+func (h *sqliteHandler) RetryCallback(id any, at time.Time) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return h.db.Exec(retryCallback, at, iid)
+}
+
+func (h *sqliteHandler) FailCallback(id any) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return h.db.Exec(failCallback, iid)
+}
+
+func (h *sqliteHandler) GetTask(id any) (*scheduler.Task, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	rows, err := h.db.Query(getTask, iid)
+	if err != nil {
+		return nil, err
+	}
+	res := &it{Rows: rows}
+	defer res.Close()
+
+	if !res.Next() {
+		if err := res.Err(); err != nil {
+			return nil, err
+		}
+		return nil, scheduler.ErrTaskNotFound
+	}
+	t := scheduler.EmptyTask()
+	if err := res.Into(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (h *sqliteHandler) FindIdempotent(key string) (int, error) {
+	var id int
+	err := h.db.QueryRow(findIdempotent, key).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, scheduler.ErrTaskNotFound
+	}
+	return id, err
+}
+
+func (h *sqliteHandler) FindGroup(key string, after time.Time) (int, error) {
+	var id int
+	err := h.db.QueryRow(findGroup, key, after).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, scheduler.ErrTaskNotFound
+	}
+	return id, err
+}
+
+func (h *sqliteHandler) MergeTask(id any, e scheduler.DigestEntry, after time.Time) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	entry, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return h.db.Exec(mergeTask, entry, iid, after)
+}
+
+func (h *sqliteHandler) ReplaceTask(id any, task *scheduler.Task, after time.Time) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	ttask, err := fromSchedulerTask(task)
+	if err != nil {
+		return nil, err
+	}
+	return h.db.Exec(replaceTask, ttask.Parameters, ttask.At, ttask.Trace, ttask.Callback, ttask.Labels, iid, after)
+}
+
+func (h *sqliteHandler) LastCompleted(key string) (time.Time, error) {
+	var at time.Time
+	err := h.db.QueryRow(lastCompleted, key).Scan(&at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return at, err
+}
+
+func (h *sqliteHandler) ListTasks(f scheduler.TaskFilter) ([]*scheduler.Task, error) {
+	var (
+		query strings.Builder
+		args  []any
+		where []string
+	)
+	query.WriteString(listTasks)
+	if f.Method != "" {
+		where = append(where, "method=?")
+		args = append(args, f.Method)
+	}
+	if f.Status != nil {
+		where = append(where, "completed=?")
+		args = append(args, int(*f.Status))
+	}
+	if len(where) > 0 {
+		query.WriteString(" WHERE ")
+		query.WriteString(strings.Join(where, " and "))
+	}
+	query.WriteString(" ORDER BY id LIMIT ? OFFSET ?")
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit, f.Offset)
+
+	rows, err := h.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	res := &it{Rows: rows}
+	defer res.Close()
+
+	var tasks []*scheduler.Task
+	for res.Next() {
+		t := scheduler.EmptyTask()
+		if err := res.Into(t); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, res.Err()
+}
+
+func (h *sqliteHandler) CancelTask(id any) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return h.db.Exec(cancelTask, iid)
+}
+
+func (h *sqliteHandler) RequeueTask(id any, at time.Time) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return h.db.Exec(requeueTask, at, iid)
+}
+
+func (h *sqliteHandler) CountTasks() (map[scheduler.TaskStatus]int, error) {
+	rows, err := h.db.Query(countTasks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[scheduler.TaskStatus]int)
+	for rows.Next() {
+		var status, n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		res[scheduler.TaskStatus(status)] = n
+	}
+	return res, rows.Err()
+}
+
+func (h *sqliteHandler) Ping(ctx context.Context) error {
+	return h.db.PingContext(ctx)
 }
 
 type transaction struct {
@@ -139,7 +446,7 @@ func (t *transaction) InsertTask(task *scheduler.Task) (scheduler.Result, error)
 	if err != nil {
 		return nil, err
 	}
-	return t.Exec(insertTask, ttask.Method, ttask.Parameters, ttask.At)
+	return t.Exec(insertTask, ttask.Method, ttask.Parameters, ttask.At, ttask.Trace, ttask.Callback, ttask.Labels, ttask.IdempotencyKey, ttask.GroupKey, string(ttask.Digest))
 }
 
 func (t *transaction) IncrementRetries(id any) (scheduler.Result, error) {
@@ -150,6 +457,41 @@ func (t *transaction) IncrementRetries(id any) (scheduler.Result, error) {
 	return t.Exec(incrRetries, iid)
 }
 
+func (t *transaction) RetryTask(id any, at time.Time) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return t.Exec(retryTask, at, iid)
+}
+
+func (t *transaction) FailTask(id any) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return t.Exec(failTask, iid)
+}
+
+func (t *transaction) InsertAttempt(a *scheduler.Attempt) (scheduler.Result, error) {
+	return t.Exec(insertAttempt, a.TaskId, a.Number, a.StartedAt, a.FinishedAt, a.Handler, a.StatusCode, a.Response, a.Error)
+}
+
+func (t *transaction) InsertCallback(cb *scheduler.Callback) (scheduler.Result, error) {
+	return t.Exec(insertCallback, cb.TaskId, cb.Url, cb.Payload, cb.NextAt)
+}
+
+func (t *transaction) Has(key string) (bool, error) {
+	return hasKey(t.Tx, key)
+}
+
+func (t *transaction) SetIfAbsent(key string, ttl time.Duration) (bool, error) {
+	return setIfAbsent(t.Tx, key, ttl)
+}
+
+// singleTransaction commits every statement on its own. It doesn't implement
+// scheduler.DedupStore, so grouping keys are stored after the batch like with
+// stores outside of the database.
 type singleTransaction struct {
 	*sql.DB
 }
@@ -167,7 +509,7 @@ func (t *singleTransaction) InsertTask(task *scheduler.Task) (scheduler.Result,
 	if err != nil {
 		return nil, err
 	}
-	return t.Exec(insertTask, ttask.Method, ttask.Parameters, ttask.At)
+	return t.Exec(insertTask, ttask.Method, ttask.Parameters, ttask.At, ttask.Trace, ttask.Callback, ttask.Labels, ttask.IdempotencyKey, ttask.GroupKey, string(ttask.Digest))
 }
 
 func (t *singleTransaction) IncrementRetries(id any) (scheduler.Result, error) {
@@ -178,6 +520,30 @@ func (t *singleTransaction) IncrementRetries(id any) (scheduler.Result, error) {
 	return t.Exec(incrRetries, iid)
 }
 
+func (t *singleTransaction) RetryTask(id any, at time.Time) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return t.Exec(retryTask, at, iid)
+}
+
+func (t *singleTransaction) FailTask(id any) (scheduler.Result, error) {
+	iid, ok := id.(int)
+	if !ok {
+		return nil, errors.New("invalid id type")
+	}
+	return t.Exec(failTask, iid)
+}
+
+func (t *singleTransaction) InsertAttempt(a *scheduler.Attempt) (scheduler.Result, error) {
+	return t.Exec(insertAttempt, a.TaskId, a.Number, a.StartedAt, a.FinishedAt, a.Handler, a.StatusCode, a.Response, a.Error)
+}
+
+func (t *singleTransaction) InsertCallback(cb *scheduler.Callback) (scheduler.Result, error) {
+	return t.Exec(insertCallback, cb.TaskId, cb.Url, cb.Payload, cb.NextAt)
+}
+
 func (t *singleTransaction) Commit() error {
 	return nil
 }